@@ -0,0 +1,259 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// CachedResponse is a response stored by a ResponseCache
+type CachedResponse struct {
+	statusCode int
+	body       []byte
+	expires    time.Time
+}
+
+// ResponseCache is a minimal client-side HTTP cache. It stores successful responses
+// to GET requests for as long as their Cache-Control max-age allows, and never
+// stores a response marked no-store or no-cache.
+type ResponseCache struct {
+	mu      sync.RWMutex
+	entries map[string]CachedResponse
+	hits    int64
+	misses  int64
+}
+
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		entries: make(map[string]CachedResponse),
+	}
+}
+
+// Do serves the request from the cache if it can, and otherwise calls fetch, which
+// makes the request and returns the response along with its Cache-Control header
+func (c *ResponseCache) Do(method, url string, fetch func() (statusCode int, body []byte, cacheControl string, err error)) (int, []byte, error) {
+	key := method + " " + url
+	if method == http.MethodGet {
+		c.mu.RLock()
+		entry, ok := c.entries[key]
+		c.mu.RUnlock()
+		if ok && time.Now().Before(entry.expires) {
+			atomic.AddInt64(&c.hits, 1)
+			// Limit the capacity so that a caller appending to the body can't write
+			// into the cached copy
+			return entry.statusCode, entry.body[:len(entry.body):len(entry.body)], nil
+		}
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	statusCode, body, cacheControl, err := fetch()
+	if err != nil {
+		return statusCode, body, err
+	}
+	if maxAge, ok := cacheableFor(cacheControl); ok && method == http.MethodGet && statusCode == http.StatusOK {
+		c.mu.Lock()
+		c.entries[key] = CachedResponse{
+			statusCode: statusCode,
+			// The caller may reuse the memory of the body it got back
+			body:    append([]byte(nil), body...),
+			expires: time.Now().Add(maxAge),
+		}
+		c.mu.Unlock()
+	}
+	return statusCode, body, nil
+}
+
+// cacheableFor returns how long a response with the given Cache-Control header may
+// be served from the cache
+func cacheableFor(cacheControl string) (time.Duration, bool) {
+	var maxAge time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				return 0, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return maxAge, maxAge > 0
+}
+
+func (c *ResponseCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]CachedResponse)
+	atomic.StoreInt64(&c.hits, 0)
+	atomic.StoreInt64(&c.misses, 0)
+}
+
+// reportResponseCache reports how many requests the cache served, and how many fewer
+// requests reached the backend as a result, which every dial represents since
+// connections aren't kept alive. Without a cache, every request is a miss.
+func reportResponseCache(b *testing.B, cache *ResponseCache, dialer *CountingDialer) {
+	hits, misses := int64(0), int64(b.N)
+	if cache != nil {
+		hits, misses = atomic.LoadInt64(&cache.hits), atomic.LoadInt64(&cache.misses)
+	}
+	if misses != dialer.Count() {
+		b.Fatalf("expected a backend request for each of the %d cache misses but got %d", misses, dialer.Count())
+	}
+	b.ReportMetric(float64(hits)/float64(hits+misses), "cache-hit-rate")
+	b.ReportMetric(1-float64(dialer.Count())/float64(b.N), "backend-reduction")
+}
+
+// makeCacheableMockResponse builds a response with the given Cache-Control header
+func makeCacheableMockResponse(cacheControl string, body []byte) []byte {
+	response := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Cache-Control: " + cacheControl + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n"
+	return append([]byte(response), body...)
+}
+
+func BenchmarkClientWithResponseCache(b *testing.B) {
+	testValue := makeJsonPayload(4 << 10)
+	testUrl := "http://host.test/catalog?page=1"
+
+	modes := []struct {
+		name         string
+		cacheControl string
+		useCache     bool
+	}{
+		{"no-cache", "public, max-age=60", false},
+		{"cached", "public, max-age=60", true},
+		// The cache must not store what the server says not to
+		{"cached-no-store", "no-store", true},
+	}
+
+	for _, mode := range modes {
+		response := makeCacheableMockResponse(mode.cacheControl, testValue)
+
+		b.Run("NetHttp/"+mode.name, func(b *testing.B) {
+			dialer := NewCountingDialer(dialMockServerWithResponse(response))
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: dialer.DialNetwork,
+					// Each request that reaches the backend dials a new connection
+					DisableKeepAlives: true,
+				},
+			}
+			fetch := func() (int, []byte, string, error) {
+				resp, err := client.Get(testUrl)
+				statusCode, body, err := netHttpResult(resp, err)
+				if err != nil {
+					return 0, nil, "", err
+				}
+				return statusCode, body, resp.Header.Get("Cache-Control"), nil
+			}
+
+			var cache *ResponseCache
+			if mode.useCache {
+				cache = NewResponseCache()
+			}
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				if cache == nil {
+					statusCode, body, _, err := fetch()
+					return statusCode, body, err
+				}
+				return cache.Do(http.MethodGet, testUrl, fetch)
+			}
+			verifyCachedResponse(b, doRequest, fetch)
+
+			// Start counting from an empty cache
+			dialer.Reset()
+			if cache != nil {
+				cache.Reset()
+			}
+			b.SetBytes(int64(len(testValue)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			runClientBenchmark(b, doRequest, testValue)
+			b.StopTimer()
+
+			reportResponseCache(b, cache, dialer)
+		})
+
+		b.Run("FastHttp/"+mode.name, func(b *testing.B) {
+			dialer := NewCountingDialer(dialMockServerWithResponse(response))
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialer.Dial,
+			}
+			fetch := func() (int, []byte, string, error) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				// Each request that reaches the backend dials a new connection
+				req.SetConnectionClose()
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				if err := client.Do(req, resp); err != nil {
+					return 0, nil, "", err
+				}
+				// The response is about to be released, so copy what's needed from it
+				body := append([]byte(nil), resp.Body()...)
+				return resp.StatusCode(), body, string(resp.Header.Peek(fasthttp.HeaderCacheControl)), nil
+			}
+
+			var cache *ResponseCache
+			if mode.useCache {
+				cache = NewResponseCache()
+			}
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				if cache == nil {
+					statusCode, body, _, err := fetch()
+					return statusCode, body, err
+				}
+				return cache.Do(http.MethodGet, testUrl, fetch)
+			}
+			verifyCachedResponse(b, doRequest, fetch)
+
+			// Start counting from an empty cache
+			dialer.Reset()
+			if cache != nil {
+				cache.Reset()
+			}
+			b.SetBytes(int64(len(testValue)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			runClientBenchmark(b, doRequest, testValue)
+			b.StopTimer()
+
+			reportResponseCache(b, cache, dialer)
+		})
+	}
+}
+
+// verifyCachedResponse checks that a response served from the cache is the same as
+// one fetched fresh from the backend
+func verifyCachedResponse(b *testing.B, doRequest func(buffer []byte) (int, []byte, error), fetch func() (int, []byte, string, error)) {
+	freshStatusCode, freshBody, _, err := fetch()
+	if err != nil {
+		b.Fatalf("client get failed: %s", err)
+	}
+	// The first request may fill the cache, and the second may be served from it
+	for i := 0; i < 2; i++ {
+		statusCode, body, err := doRequest(nil)
+		if err != nil {
+			b.Fatalf("client get failed: %s", err)
+		}
+		if statusCode != freshStatusCode || !bytes.Equal(body, freshBody) {
+			b.Fatalf("expected the cached response to match a fresh one")
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package fasthttp_request_perf
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of consecutive failures that trip a circuit breaker
+const circuitBreakerThreshold = 5
+
+type CircuitState int
+
+const (
+	// Requests go through, and failures are counted
+	circuitClosed CircuitState = iota
+	// Requests fail straight away without reaching the backend
+	circuitOpen
+	// A single trial request goes through to find out whether the backend has recovered
+	circuitHalfOpen
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops sending requests to a backend after it fails several times in
+// a row, then lets a trial request through once the cooldown has passed
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	threshold int
+	cooldown  time.Duration
+
+	shortCircuits int64
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Do makes the request unless the breaker is open. A request fails if it returns an
+// error or a 5xx status code.
+func (cb *CircuitBreaker) Do(request func() (statusCode int, body []byte, err error)) (int, []byte, error) {
+	if !cb.allow() {
+		atomic.AddInt64(&cb.shortCircuits, 1)
+		return 0, nil, ErrCircuitOpen
+	}
+	statusCode, body, err := request()
+	cb.record(err == nil && statusCode < http.StatusInternalServerError)
+	return statusCode, body, err
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// The trial request is still in flight
+		return false
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if success {
+		cb.state = circuitClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) ShortCircuits() int64 {
+	return atomic.LoadInt64(&cb.shortCircuits)
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	cooldown := 10 * time.Millisecond
+	breaker := NewCircuitBreaker(circuitBreakerThreshold, cooldown)
+
+	var requests int
+	healthy := false
+	request := func() (int, []byte, error) {
+		requests++
+		if !healthy {
+			return http.StatusServiceUnavailable, nil, nil
+		}
+		return http.StatusOK, nil, nil
+	}
+	expectState := func(expected CircuitState) {
+		if state := breaker.State(); state != expected {
+			t.Fatalf("expected circuit state %d but got %d", expected, state)
+		}
+	}
+
+	// Failures trip the breaker once there are enough of them in a row
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		expectState(circuitClosed)
+		breaker.Do(request)
+	}
+	expectState(circuitOpen)
+
+	// While open, requests don't reach the backend
+	if _, _, err := breaker.Do(request); err != ErrCircuitOpen {
+		t.Fatalf("expected %q but got: %v", ErrCircuitOpen, err)
+	}
+	if requests != circuitBreakerThreshold {
+		t.Fatalf("expected %d requests to reach the backend but got %d", circuitBreakerThreshold, requests)
+	}
+
+	// After the cooldown a failing trial request opens the breaker again straight away
+	time.Sleep(2 * cooldown)
+	breaker.Do(request)
+	expectState(circuitOpen)
+
+	// And a successful one closes it
+	time.Sleep(2 * cooldown)
+	healthy = true
+	if _, _, err := breaker.Do(request); err != nil {
+		t.Fatalf("expected the trial request to succeed but got: %s", err)
+	}
+	expectState(circuitClosed)
+	if requests != circuitBreakerThreshold+2 {
+		t.Fatalf("expected %d requests to reach the backend but got %d", circuitBreakerThreshold+2, requests)
+	}
+}
+
+// runCircuitBreakerBenchmark makes b.N requests, through a breaker if there is one.
+// Against a failing backend, the breaker is tripped first, and its cooldown outlasts
+// the benchmark, so it has to short-circuit every request.
+func runCircuitBreakerBenchmark(b *testing.B, dialer *CountingDialer, healthy, useBreaker bool, request func() (int, []byte, error), expected []byte) {
+	var breaker *CircuitBreaker
+	if useBreaker {
+		breaker = NewCircuitBreaker(circuitBreakerThreshold, time.Hour)
+		if !healthy {
+			for i := 0; i < circuitBreakerThreshold; i++ {
+				breaker.Do(request)
+			}
+			if breaker.State() != circuitOpen {
+				b.Fatalf("expected the failing backend to trip the breaker")
+			}
+		}
+	}
+
+	dialer.Reset()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var statusCode int
+		var body []byte
+		var err error
+		if breaker != nil {
+			statusCode, body, err = breaker.Do(request)
+		} else {
+			statusCode, body, err = request()
+		}
+
+		if !healthy {
+			if err == nil {
+				b.Fatalf("expected the request to the failing backend to fail")
+			}
+			continue
+		}
+		if err != nil {
+			b.Fatalf("client request failed: %s", err)
+		}
+		if statusCode != http.StatusOK {
+			b.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+		}
+		if string(body) != string(expected) {
+			b.Fatalf("expected body %q but got %q", expected, body)
+		}
+	}
+	b.StopTimer()
+
+	if breaker != nil && !healthy {
+		if dialer.Count() != 0 {
+			b.Fatalf("expected the open breaker to short-circuit every request but %d dials reached the backend", dialer.Count())
+		}
+		b.ReportMetric(float64(breaker.ShortCircuits())/float64(b.N), "short-circuits/op")
+	}
+	b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+}
+
+// BenchmarkClientWithCircuitBreaker wraps each client in a circuit breaker. The
+// overhead of the breaker while closed is the difference between healthy/breaker and
+// healthy/no-breaker, and the savings while open the difference between
+// failing/no-breaker and failing/breaker. The failing backend breaks every
+// connection with a short write.
+func BenchmarkClientWithCircuitBreaker(b *testing.B) {
+	testValue := []byte("123")
+	testUrl := "http://host.test/query"
+
+	for _, healthy := range []bool{true, false} {
+		backend := "healthy"
+		dial := dialMockServer
+		if !healthy {
+			backend = "failing"
+			dial = dialMockServerWithFault(mockFaultShortWrite)
+		}
+
+		for _, useBreaker := range []bool{false, true} {
+			name := backend + "/no-breaker"
+			if useBreaker {
+				name = backend + "/breaker"
+			}
+
+			b.Run("NetHttp/"+name, func(b *testing.B) {
+				dialer := NewCountingDialer(dial)
+				// Create an http.Client
+				client := &http.Client{
+					Transport: &http.Transport{
+						Dial: dialer.DialNetwork,
+						// Set the maximum number of idle connections equal to the max number of processes
+						MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+					},
+				}
+
+				runCircuitBreakerBenchmark(b, dialer, healthy, useBreaker, func() (int, []byte, error) {
+					return netHttpResult(client.Get(testUrl))
+				}, testValue)
+			})
+
+			b.Run("FastHttp/"+name, func(b *testing.B) {
+				dialer := NewCountingDialer(dial)
+				// Create a client
+				client := &fasthttp.Client{
+					Dial: dialer.Dial,
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+
+				var buffer []byte
+				runCircuitBreakerBenchmark(b, dialer, healthy, useBreaker, func() (int, []byte, error) {
+					// Append the body to the one from the previous request to reuse its memory
+					statusCode, body, err := client.Get(buffer[:0], testUrl)
+					buffer = body
+					return statusCode, body, err
+				}, testValue)
+			})
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package fasthttp_request_perf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The number of values that each request's context carries, from none up to what a
+// request picks up passing through tracing, auth and logging middleware
+var contextValueCounts = []int{0, 4, 16}
+
+// contextValueKey is the key of the i-th value in a request's context
+type contextValueKey int
+
+// makeContextValues returns the values to attach to each request. They're made ahead
+// of time, so attaching them only allocates the contexts that hold them.
+func makeContextValues(count int) []any {
+	values := make([]any, count)
+	for i := range values {
+		values[i] = "value-" + strconv.Itoa(i)
+	}
+	return values
+}
+
+// withContextValues attaches every value to the context, each under its own key
+func withContextValues(ctx context.Context, values []any) context.Context {
+	for i, value := range values {
+		ctx = context.WithValue(ctx, contextValueKey(i), value)
+	}
+	return ctx
+}
+
+// ContextCheckingRoundTripper reads every value that the request's context should
+// carry before passing the request on, as an instrumented transport would, and fails
+// the request if any of them didn't get through
+type ContextCheckingRoundTripper struct {
+	next   http.RoundTripper
+	values []any
+}
+
+func (t *ContextCheckingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for i, expected := range t.values {
+		if value := ctx.Value(contextValueKey(i)); value != expected {
+			return nil, fmt.Errorf("expected context value %d to be %v but got %v", i, expected, value)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// reportContextValueOverhead times attaching the values to a context and reading them
+// back on their own, and reports it as context-ns and as context-% of the time each
+// request took
+func reportContextValueOverhead(b *testing.B, values []any) {
+	b.StopTimer()
+	requestNs := float64(b.Elapsed().Nanoseconds()) / float64(b.N)
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		ctx := withContextValues(context.Background(), values)
+		for j := range values {
+			ctx.Value(contextValueKey(j))
+		}
+	}
+	contextNs := float64(time.Since(start).Nanoseconds()) / float64(b.N)
+
+	b.ReportMetric(contextNs, "context-ns")
+	b.ReportMetric(100*contextNs/requestNs, "context-%")
+}
+
+// BenchmarkNetHttpClientContextValues attaches values to the context of every request
+// and reads them all in the client's RoundTripper. Each value wraps the context in
+// another one, and every lookup walks back through them, so both the allocations and
+// the time grow with the number of values.
+//
+// fasthttp has no counterpart to measure: its client takes no context, so whatever a
+// request needs has to be passed alongside it, and deadlines are set with DoTimeout
+// or DoDeadline. On the server, a fasthttp.RequestCtx carries values of its own with
+// SetUserValue and UserValue, which are stored in a slice that's reused for every
+// request on the connection.
+func BenchmarkNetHttpClientContextValues(b *testing.B) {
+	testValue := []byte("123")
+	testUrl := "http://host.test/query"
+
+	for _, count := range contextValueCounts {
+		values := makeContextValues(count)
+
+		b.Run(fmt.Sprintf("values=%d", count), func(b *testing.B) {
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &ContextCheckingRoundTripper{
+					next:   newNetHttpClientToMockServer().Transport,
+					values: values,
+				},
+			}
+
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				ctx := withContextValues(context.Background(), values)
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, testUrl, nil)
+				if err != nil {
+					return 0, nil, err
+				}
+				return netHttpResult(client.Do(req))
+			}, testValue)
+			reportContextValueOverhead(b, values)
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Methods that HTTP allows but that aren't among the standard ones, as used by WebDAV
+// and custom protocols
+var customMethods = []string{"PROPFIND", "REPORT", "MKCOL", "X-CUSTOM-VERB", "M!#$%&'*+-.^_`|~9"}
+
+// Methods that contain characters outside of an HTTP token
+var invalidMethods = []string{"BAD(", "BAD\"", "BAD@", "BAD,", "BAD\x7f", "\xc3\x9cBER"}
+
+// isMethodToken reports whether the method is an HTTP token as defined by RFC 7230.
+// The fasthttp server takes whatever precedes the first space of the request line as
+// the method, so the handler has to check it.
+func isMethodToken(method []byte) bool {
+	if len(method) == 0 {
+		return false
+	}
+	for _, c := range method {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case bytes.IndexByte([]byte("!#$%&'*+-.^_`|~"), c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func handleCustomMethodRequest(ctx *fasthttp.RequestCtx) {
+	method := ctx.Method()
+	if !isMethodToken(method) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		return
+	}
+
+	// Echo the method so that clients can check it arrived intact
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(method)
+}
+
+func TestClientCustomMethod(t *testing.T) {
+	// Start a server
+	server := startTcpServerWithHandler(t, handleCustomMethodRequest)
+	defer server.Stop(t)
+
+	testUrl := "http://" + server.hostAddress + "/resource"
+
+	t.Run("NetHttp", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{},
+		}
+
+		for _, method := range customMethods {
+			req, err := http.NewRequest(method, testUrl, nil)
+			if err != nil {
+				t.Fatalf("cannot create %q request: %s", method, err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("client %q failed: %s", method, err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("error while reading response body: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if string(body) != method {
+				t.Fatalf("expected body %q but got %q", method, body)
+			}
+		}
+
+		// net/http refuses to build a request with an invalid method, so it never
+		// reaches the server
+		for _, method := range invalidMethods {
+			if _, err := http.NewRequest(method, testUrl, nil); err == nil {
+				t.Fatalf("expected the invalid method %q to be rejected", method)
+			}
+		}
+	})
+
+	t.Run("FastHttp", func(t *testing.T) {
+		client := &fasthttp.Client{}
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(testUrl)
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		for _, method := range customMethods {
+			req.Header.SetMethod(method)
+			if err := client.Do(req, resp); err != nil {
+				t.Fatalf("client %q failed: %s", method, err)
+			}
+			if resp.StatusCode() != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			}
+			if string(resp.Body()) != method {
+				t.Fatalf("expected body %q but got %q", method, resp.Body())
+			}
+		}
+
+		// The fasthttp client sends any method as is, so it's up to the server to
+		// reject one that isn't a token
+		for _, method := range invalidMethods {
+			req.Header.SetMethod(method)
+			if err := client.Do(req, resp); err != nil {
+				t.Fatalf("client %q failed: %s", method, err)
+			}
+			if resp.StatusCode() != fasthttp.StatusBadRequest {
+				t.Fatalf("expected status code %d for the invalid method %q but got %d", fasthttp.StatusBadRequest, method, resp.StatusCode())
+			}
+		}
+	})
+}
+
+func BenchmarkClientCustomMethod(b *testing.B) {
+	methods := append([]string{fasthttp.MethodGet}, customMethods[:3]...)
+
+	for _, method := range methods {
+		// Parsing a raw request isolates the cost of recognizing the method
+		rawRequest := []byte(method + " /resource HTTP/1.1\r\nHost: host.test\r\nUser-Agent: bench\r\n\r\n")
+
+		b.Run(fmt.Sprintf("NetHttp/parse/method=%s", method), func(b *testing.B) {
+			reader := bytes.NewReader(rawRequest)
+			bufferedReader := bufio.NewReader(reader)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader.Reset(rawRequest)
+				bufferedReader.Reset(reader)
+				req, err := http.ReadRequest(bufferedReader)
+				if err != nil {
+					b.Fatalf("cannot parse request: %s", err)
+				}
+				if req.Method != method {
+					b.Fatalf("expected method %q but got %q", method, req.Method)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("FastHttp/parse/method=%s", method), func(b *testing.B) {
+			reader := bytes.NewReader(rawRequest)
+			bufferedReader := bufio.NewReader(reader)
+			var header fasthttp.RequestHeader
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader.Reset(rawRequest)
+				bufferedReader.Reset(reader)
+				if err := header.Read(bufferedReader); err != nil {
+					b.Fatalf("cannot parse request: %s", err)
+				}
+				if string(header.Method()) != method {
+					b.Fatalf("expected method %q but got %q", method, header.Method())
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("NetHttp/request/method=%s", method), func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleCustomMethodRequest)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testUrl := "http://" + server.hostAddress + "/resource"
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req, err := http.NewRequest(method, testUrl, nil)
+					if err != nil {
+						b.Fatalf("cannot create request: %s", err)
+					}
+					resp, err := client.Do(req)
+					if err != nil {
+						b.Fatalf("client %s failed: %s", method, err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if string(body) != method {
+						b.Fatalf("expected body %q but got %q", method, body)
+					}
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("FastHttp/request/method=%s", method), func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleCustomMethodRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://" + server.hostAddress + "/resource"
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				req.Header.SetMethod(method)
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				for pb.Next() {
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client %s failed: %s", method, err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != method {
+						b.Fatalf("expected body %q but got %q", method, resp.Body())
+					}
+				}
+			})
+		})
+	}
+}
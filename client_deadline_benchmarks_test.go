@@ -0,0 +1,150 @@
+package fasthttp_request_perf
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// How long a connection may go without any bytes being read or written
+const idleTimeout = time.Second
+
+// DeadlineConn enforces a strict idle timeout by pushing back the deadline before
+// every read and write, rather than once per request as the clients' own timeouts do
+type DeadlineConn struct {
+	net.Conn
+	deadlines *int64
+}
+
+func (c DeadlineConn) Read(b []byte) (int, error) {
+	atomic.AddInt64(c.deadlines, 1)
+	if err := c.Conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c DeadlineConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.deadlines, 1)
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// dialWithPerReadDeadline returns a dial function whose connections set a deadline
+// before every read and write, and add each one to the counter
+func dialWithPerReadDeadline(dial fasthttp.DialFunc, deadlines *int64) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return DeadlineConn{Conn: conn, deadlines: deadlines}, nil
+	}
+}
+
+// The ways to enforce timeouts that BenchmarkClientPerReadDeadline compares
+var deadlineModes = []string{"no-timeout", "client-timeout", "per-read-deadline"}
+
+// BenchmarkClientPerReadDeadline compares setting a deadline before every read and
+// write with the timeout fields built into each client. The body is larger than the
+// clients' default 4KB read buffers, so it takes several reads, and deadlines/op
+// shows how many deadlines each request set. Setting a deadline on a TCP
+// connection doesn't make a system call in Go, but it does reset the runtime timer
+// of the connection, which the TCP variants include and the mock ones don't.
+func BenchmarkClientPerReadDeadline(b *testing.B) {
+	bodySize := 64 << 10
+	testValue := makePayload(bodySize)
+
+	for _, overTcp := range []bool{false, true} {
+		network := "Mock"
+		if overTcp {
+			network = "TCP"
+		}
+
+		for _, mode := range deadlineModes {
+			b.Run("NetHttp/"+network+"/"+mode, func(b *testing.B) {
+				testUrl := "http://host.test/query"
+				dial := dialMockServerWithBodySize(bodySize)
+				if overTcp {
+					// Start a server
+					server := startTcpServerWithHandler(b, handleFixedBodyRequest(testValue))
+					defer server.Stop(b)
+					testUrl = "http://" + server.hostAddress + "/query"
+					dial = fasthttp.Dial
+				}
+
+				var deadlines int64
+				transport := &http.Transport{
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+				switch mode {
+				case "client-timeout":
+					// The transport times requests out with timers instead of deadlines
+					transport.ResponseHeaderTimeout = idleTimeout
+					transport.IdleConnTimeout = idleTimeout
+				case "per-read-deadline":
+					dial = dialWithPerReadDeadline(dial, &deadlines)
+				}
+				transport.Dial = func(network, addr string) (net.Conn, error) {
+					return dial(addr)
+				}
+				// Create an http.Client
+				client := &http.Client{Transport: transport}
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					return netHttpResult(client.Get(testUrl))
+				}, testValue)
+
+				b.ReportMetric(float64(atomic.LoadInt64(&deadlines))/float64(b.N), "deadlines/op")
+			})
+
+			b.Run("FastHttp/"+network+"/"+mode, func(b *testing.B) {
+				testUrl := "http://host.test/query"
+				dial := dialMockServerWithBodySize(bodySize)
+				if overTcp {
+					// Start a server
+					server := startTcpServerWithHandler(b, handleFixedBodyRequest(testValue))
+					defer server.Stop(b)
+					testUrl = "http://" + server.hostAddress + "/query"
+					dial = fasthttp.Dial
+				}
+
+				var deadlines int64
+				// Create a client
+				client := &fasthttp.Client{
+					// Set the maximum number of connections equal to the max number of processes
+					MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+				switch mode {
+				case "client-timeout":
+					// The client sets each deadline once per request
+					client.ReadTimeout = idleTimeout
+					client.WriteTimeout = idleTimeout
+					client.MaxIdleConnDuration = idleTimeout
+				case "per-read-deadline":
+					dial = dialWithPerReadDeadline(dial, &deadlines)
+				}
+				client.Dial = dial
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					// Append the body to the one from the previous request to reuse its memory
+					return client.Get(buffer, testUrl)
+				}, testValue)
+
+				b.ReportMetric(float64(atomic.LoadInt64(&deadlines))/float64(b.N), "deadlines/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package fasthttp_request_perf
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// A hostname rather than an IP address, so that every dial may need a resolution
+const dnsTestHostname = "localhost"
+
+// CountingResolver counts how many lookups reach the system resolver. It satisfies
+// fasthttp.Resolver, so it can be used by a fasthttp.TCPDialer.
+type CountingResolver struct {
+	lookups int64
+}
+
+func (r *CountingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt64(&r.lookups, 1)
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+func (r *CountingResolver) Count() int64 {
+	return atomic.LoadInt64(&r.lookups)
+}
+
+// skipUnlessResolvesToLoopback skips the benchmark if the hostname doesn't resolve to
+// the IPv4 loopback address on which the TCP server listens
+func skipUnlessResolvesToLoopback(b *testing.B, hostname string) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), hostname)
+	if err != nil {
+		b.Skipf("cannot resolve %q: %s", hostname, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			return
+		}
+	}
+	b.Skipf("%q doesn't resolve to 127.0.0.1, only to %v", hostname, addrs)
+}
+
+// DialTrace counts the resolutions and new connections of the requests that carry its
+// httptrace.ClientTrace, as net/http itself makes them
+type DialTrace struct {
+	resolutions int64
+	dials       int64
+}
+
+func (t *DialTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			atomic.AddInt64(&t.resolutions, 1)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				atomic.AddInt64(&t.dials, 1)
+			}
+		},
+	}
+}
+
+// hostnameAddress is the address of the server with the hostname in place of its IP
+func hostnameAddress(server *TcpServer) string {
+	_, port, _ := net.SplitHostPort(server.hostAddress)
+	return net.JoinHostPort(dnsTestHostname, port)
+}
+
+func reportDialsAndResolutions(b *testing.B, dials int64, resolutions int64) {
+	b.ReportMetric(float64(dials)/float64(b.N), "dials/op")
+	b.ReportMetric(float64(resolutions)/float64(b.N), "resolutions/op")
+}
+
+// BenchmarkClientDNSResolutionLoad closes the connection after every request so that
+// each request dials, and counts how often the hostname has to be resolved.
+// fasthttp.TCPDialer caches resolutions, whereas net/http resolves on every dial.
+//
+// net/http keeps the Transport's own dialer, so the lookups and the dialing measured
+// are its own, including trying every address the hostname resolves to. They're
+// counted with an httptrace.ClientTrace on the request.
+func BenchmarkClientDNSResolutionLoad(b *testing.B) {
+	skipUnlessResolvesToLoopback(b, dnsTestHostname)
+
+	testValue := "123"
+
+	b.Run("NetHttp", func(b *testing.B) {
+		// Start a server
+		server := startTcpServer(b)
+		defer server.Stop(b)
+
+		// Create an http.Client
+		client := &http.Client{
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+			},
+		}
+
+		trace := &DialTrace{}
+		testUrl := "http://" + hostnameAddress(server) + "/query?q=" + testValue
+		ctx := httptrace.WithClientTrace(context.Background(), trace.ClientTrace())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, testUrl, nil)
+		if err != nil {
+			b.Fatalf("cannot create request: %s", err)
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp, err := client.Do(req)
+			if err != nil {
+				b.Fatalf("client get failed: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			// Read the response body
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				b.Fatalf("error while reading response body: %s", err)
+			}
+			if string(body) != testValue {
+				b.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		}
+
+		reportDialsAndResolutions(b, atomic.LoadInt64(&trace.dials), atomic.LoadInt64(&trace.resolutions))
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		// Start a server
+		server := startTcpServer(b)
+		defer server.Stop(b)
+
+		resolver := &CountingResolver{}
+		tcpDialer := &fasthttp.TCPDialer{
+			Resolver:         resolver,
+			DNSCacheDuration: time.Minute,
+		}
+		dialer := NewCountingDialer(tcpDialer.Dial)
+		client := &fasthttp.Client{
+			Dial: dialer.Dial,
+		}
+
+		testUrl := "http://" + hostnameAddress(server) + "/query?q=" + testValue
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(testUrl)
+		req.SetConnectionClose()
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			err := client.Do(req, resp)
+			if err != nil {
+				b.Fatalf("client get failed: %s", err)
+			}
+			if resp.StatusCode() != fasthttp.StatusOK {
+				b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			}
+			if string(resp.Body()) != testValue {
+				b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+			}
+		}
+
+		reportDialsAndResolutions(b, dialer.Count(), resolver.Count())
+	})
+}
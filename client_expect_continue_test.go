@@ -0,0 +1,125 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// How long net/http waits for a "100 Continue" before sending the body anyway
+const expectContinueTimeout = 50 * time.Millisecond
+
+// FirstReadRecordingConn keeps what the client wrote to the connection before it first
+// read from it, which shows whether it waited for an interim response before sending
+// the body
+type FirstReadRecordingConn struct {
+	net.Conn
+	written           bytes.Buffer
+	writtenBeforeRead []byte
+}
+
+func (c *FirstReadRecordingConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+func (c *FirstReadRecordingConn) Read(b []byte) (int, error) {
+	if c.writtenBeforeRead == nil {
+		c.writtenBeforeRead = append([]byte{}, c.written.Bytes()...)
+	}
+	return c.Conn.Read(b)
+}
+
+// TestClientExpect100Timeout relies on the MockConn not responding until it has
+// received the request body, so it never sends a "100 Continue"
+func TestClientExpect100Timeout(t *testing.T) {
+	testValue := "123"
+	testUrl := "http://host.test/upload"
+	requestBody := []byte("{\"name\":\"test\"}")
+
+	t.Run("NetHttp", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return dialMockServer(addr)
+				},
+				ExpectContinueTimeout: expectContinueTimeout,
+			},
+		}
+
+		req, err := http.NewRequest(http.MethodPost, testUrl, bytes.NewReader(requestBody))
+		if err != nil {
+			t.Fatalf("cannot create request: %s", err)
+		}
+		req.Header.Set("Expect", "100-continue")
+
+		// net/http writes the headers, waits for the interim response until the
+		// timeout, and then sends the body regardless
+		start := time.Now()
+		resp, err := client.Do(req)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("client post failed: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error while reading response body: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+		}
+		if string(body) != testValue {
+			t.Fatalf("expected body %q but got %q", testValue, body)
+		}
+		// The timeout is only a lower bound, which a slow machine can't fail
+		if elapsed < expectContinueTimeout {
+			t.Fatalf("expected the body to be sent after the %s timeout but the request took %s", expectContinueTimeout, elapsed)
+		}
+		t.Logf("net/http completed after %s", elapsed)
+	})
+
+	t.Run("FastHttp", func(t *testing.T) {
+		var conn *FirstReadRecordingConn
+		client := &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				mockConn, err := dialMockServer(addr)
+				conn = &FirstReadRecordingConn{Conn: mockConn}
+				return conn, err
+			},
+		}
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(testUrl)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.Header.Set("Expect", "100-continue")
+		req.SetBody(requestBody)
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		// The fasthttp client doesn't wait for an interim response at all. It sends the
+		// body along with the headers, and only skips a "100 Continue" if one arrives.
+		err := client.Do(req, resp)
+		if err != nil {
+			t.Fatalf("client post failed: %s", err)
+		}
+		if resp.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+		}
+		if string(resp.Body()) != testValue {
+			t.Fatalf("expected body %q but got %q", testValue, resp.Body())
+		}
+		// Rather than timing the request, check that the body was written before the
+		// client started reading the response
+		if !bytes.HasSuffix(conn.writtenBeforeRead, requestBody) {
+			t.Fatalf("expected the body to be sent before reading the response but only %q was", conn.writtenBeforeRead)
+		}
+	})
+}
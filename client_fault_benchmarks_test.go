@@ -0,0 +1,113 @@
+package fasthttp_request_perf
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// How long the clients wait on a connection before giving up
+const faultTimeout = 5 * time.Millisecond
+
+var mockFaults = []MockFault{mockFaultUnexpectedEOF, mockFaultTimeout, mockFaultShortWrite}
+
+// dialMockServerWithFaultCountingCloses returns a dial function whose connections
+// simulate the fault and count how many times they're closed
+func dialMockServerWithFaultCountingCloses(fault MockFault, closes *int64) fasthttp.DialFunc {
+	dial := dialMockServerWithFault(fault)
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return CloseCountingConn{MockConn: conn.(*MockConn), closes: closes}, nil
+	}
+}
+
+// expectFault checks that a request failed the way the fault should make it fail
+func expectFault(b *testing.B, fault MockFault, err error) {
+	if err == nil {
+		b.Fatalf("expected the request to fail with a %s fault", fault)
+	}
+	// fasthttp.ErrTimeout only implements the Timeout method of net.Error
+	var timeoutErr interface{ Timeout() bool }
+	if isTimeout := errors.As(err, &timeoutErr) && timeoutErr.Timeout(); isTimeout != (fault == mockFaultTimeout) {
+		b.Fatalf("expected a %s fault but got: %s", fault, err)
+	}
+}
+
+// reportFaultyConnections checks that every connection a fault broke was closed rather
+// than returned to the pool, where the next request would reuse it, and reports how
+// many connections each request took, which is more than one if the client retried
+func reportFaultyConnections(b *testing.B, dialer *CountingDialer, closes *int64) {
+	if dialer.Count() < int64(b.N) {
+		b.Fatalf("expected a new connection for each of the %d requests but got %d", b.N, dialer.Count())
+	}
+	expectClosedConnections(b, dialer, closes)
+	b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+}
+
+// BenchmarkClientNetworkFaults measures the cost of the error-handling path when the
+// connection breaks mid-response, the response never arrives, or the request can't
+// be written in full
+func BenchmarkClientNetworkFaults(b *testing.B) {
+	testUrl := "http://host.test/query"
+
+	for _, fault := range mockFaults {
+		b.Run("NetHttp/"+fault.String(), func(b *testing.B) {
+			var closes int64
+			dialer := NewCountingDialer(dialMockServerWithFaultCountingCloses(fault, &closes))
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: dialer.DialNetwork,
+					// The transport has no write timeout, and rather than setting a read
+					// deadline, it closes the connection once this has passed
+					ResponseHeaderTimeout: faultTimeout,
+				},
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err := netHttpResult(client.Get(testUrl))
+				expectFault(b, fault, err)
+			}
+			b.StopTimer()
+
+			reportFaultyConnections(b, dialer, &closes)
+		})
+
+		b.Run("FastHttp/"+fault.String(), func(b *testing.B) {
+			var closes int64
+			dialer := NewCountingDialer(dialMockServerWithFaultCountingCloses(fault, &closes))
+			// Create a client
+			client := &fasthttp.Client{
+				Dial:         dialer.Dial,
+				ReadTimeout:  faultTimeout,
+				WriteTimeout: faultTimeout,
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := client.Do(req, resp)
+				expectFault(b, fault, err)
+			}
+			b.StopTimer()
+
+			reportFaultyConnections(b, dialer, &closes)
+		})
+	}
+}
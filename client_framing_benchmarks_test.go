@@ -0,0 +1,260 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The size of the chunks of a chunked response
+const framingChunkSize = 4 << 10
+
+// FramingMode is a way for a response to mark where its body ends
+type FramingMode struct {
+	name     string
+	response []byte
+	// The body only ends when the server closes the connection
+	closeDelimited bool
+}
+
+// makeChunkedMockResponse builds a response that sends the body in chunks of the
+// given size, without a Content-Length
+func makeChunkedMockResponse(body []byte, chunkSize int) []byte {
+	response := []byte("HTTP/1.1 200 OK\r\nContent-Type: test/plain\r\nTransfer-Encoding: chunked\r\n\r\n")
+	for len(body) > 0 {
+		chunk := body
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		response = strconv.AppendInt(response, int64(len(chunk)), 16)
+		response = append(response, "\r\n"...)
+		response = append(response, chunk...)
+		response = append(response, "\r\n"...)
+		body = body[len(chunk):]
+	}
+	return append(response, "0\r\n\r\n"...)
+}
+
+// makeFramingModes returns responses that frame the same body in each of the three
+// ways HTTP/1.1 allows
+func makeFramingModes(body []byte) []FramingMode {
+	return []FramingMode{
+		{name: "content-length", response: makeMockResponse("test/plain", body)},
+		{name: "chunked", response: makeChunkedMockResponse(body, framingChunkSize)},
+		{
+			name:           "close-delimited",
+			response:       append([]byte("HTTP/1.1 200 OK\r\nContent-Type: test/plain\r\nConnection: close\r\n\r\n"), body...),
+			closeDelimited: true,
+		},
+	}
+}
+
+// dialMockServerWithFraming returns a dial function whose connections frame every
+// response as the mode does
+func dialMockServerWithFraming(mode FramingMode) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn := mockServerConnectionPool.Get().(*MockConn)
+		conn.response = mode.response
+		conn.closeAfterResponse = mode.closeDelimited
+		return conn, nil
+	}
+}
+
+// newNetHttpClientWithFraming returns a client whose connections are served by a
+// MockConn that frames responses as the mode does
+func newNetHttpClientWithFraming(mode FramingMode) *http.Client {
+	dial := dialMockServerWithFraming(mode)
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return dial(addr)
+			},
+		},
+	}
+}
+
+func TestClientBodyFramingModes(t *testing.T) {
+	testValue := makePayload(64 << 10)
+	testUrl := "http://host.test/query"
+
+	for _, mode := range makeFramingModes(testValue) {
+		// Several requests in a row check that the end of each body was found, and that
+		// a connection is only reused if the body didn't end by closing it
+		t.Run("NetHttp/"+mode.name, func(t *testing.T) {
+			client := newNetHttpClientWithFraming(mode)
+			for i := 0; i < 3; i++ {
+				statusCode, body, err := netHttpResult(client.Get(testUrl))
+				if err != nil {
+					t.Fatalf("client get failed: %s", err)
+				}
+				if statusCode != http.StatusOK {
+					t.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+				}
+				if !bytes.Equal(body, testValue) {
+					t.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+				}
+			}
+		})
+
+		t.Run("FastHttp/"+mode.name, func(t *testing.T) {
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithFraming(mode),
+			}
+			for i := 0; i < 3; i++ {
+				statusCode, body, err := client.Get(nil, testUrl)
+				if err != nil {
+					t.Fatalf("client get failed: %s", err)
+				}
+				if statusCode != fasthttp.StatusOK {
+					t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+				}
+				if !bytes.Equal(body, testValue) {
+					t.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkClientBodyFramingModes(b *testing.B) {
+	for _, bodySize := range []int{1 << 10, 64 << 10} {
+		testValue := makePayload(bodySize)
+		testUrl := "http://host.test/query"
+
+		for _, mode := range makeFramingModes(testValue) {
+			b.Run(fmt.Sprintf("NetHttp/%s/size=%dKB", mode.name, bodySize>>10), func(b *testing.B) {
+				client := newNetHttpClientWithFraming(mode)
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					return netHttpResult(client.Get(testUrl))
+				}, testValue)
+			})
+
+			b.Run(fmt.Sprintf("FastHttp/%s/size=%dKB", mode.name, bodySize>>10), func(b *testing.B) {
+				// Create a client
+				client := &fasthttp.Client{
+					Dial: dialMockServerWithFraming(mode),
+				}
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					// Append the body to the one from the previous request to reuse its memory
+					return client.Get(buffer, testUrl)
+				}, testValue)
+			})
+		}
+	}
+}
+
+// Chunk sizes from many small chunks, as from a server flushing every write, to a
+// few large ones
+var mockChunkSizes = []int{64, 1 << 10, 16 << 10}
+
+// The body of the chunked responses, split into 1024, 64 and 4 chunks
+const mockChunkedBodySize = 64 << 10
+
+// The most allocations that any two chunk sizes may differ by, which leaves room for a
+// pooled buffer being replaced now and then. Allocating for each chunk would add
+// hundreds.
+const chunkedAllocSlack = 8
+
+func TestFastHttpChunkedParsingDoesNotAllocatePerChunk(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes the pools behind fasthttp's buffers drop them at random")
+	}
+	testValue := makePayload(mockChunkedBodySize)
+	testUrl := "http://host.test/query"
+
+	var allocs []float64
+	for _, chunkSize := range mockChunkSizes {
+		client := &fasthttp.Client{
+			Dial: dialMockServerWithResponse(makeChunkedMockResponse(testValue, chunkSize)),
+		}
+		var buffer []byte
+		allocs = append(allocs, testing.AllocsPerRun(100, func() {
+			statusCode, body, err := client.Get(buffer[:0], testUrl)
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+			}
+			if !bytes.Equal(body, testValue) {
+				t.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+			}
+			buffer = body
+		}))
+	}
+	for i := range mockChunkSizes {
+		for j := i + 1; j < len(mockChunkSizes); j++ {
+			if math.Abs(allocs[i]-allocs[j]) > chunkedAllocSlack {
+				t.Fatalf("expected %d chunks to take about as many allocations as %d but they took %.0f against %.0f",
+					mockChunkedBodySize/mockChunkSizes[i], mockChunkedBodySize/mockChunkSizes[j], allocs[i], allocs[j])
+			}
+		}
+	}
+}
+
+func BenchmarkNetHttpClientChunkedToMockServer(b *testing.B) {
+	testValue := makePayload(mockChunkedBodySize)
+
+	for _, chunkSize := range mockChunkSizes {
+		b.Run(fmt.Sprintf("chunks=%d", mockChunkedBodySize/chunkSize), func(b *testing.B) {
+			dial := dialMockServerWithResponse(makeChunkedMockResponse(testValue, chunkSize))
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(mockChunkedBodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+func BenchmarkFastHttpClientChunkedToMockServer(b *testing.B) {
+	testValue := makePayload(mockChunkedBodySize)
+
+	for _, chunkSize := range mockChunkSizes {
+		b.Run(fmt.Sprintf("chunks=%d", mockChunkedBodySize/chunkSize), func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithResponse(makeChunkedMockResponse(testValue, chunkSize)),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(mockChunkedBodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
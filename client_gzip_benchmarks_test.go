@@ -0,0 +1,334 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The last header of the large header block, which a client can only find after
+// parsing all of the others
+const deepHeaderName, deepHeaderValue = "X-Deep-Header", "found-it"
+
+// makeLargeHeaderBlock builds the kind of headers that a real API behind a CDN and
+// a web application firewall responds with: cookies, security policies, and caching
+func makeLargeHeaderBlock() string {
+	var headers strings.Builder
+	headers.WriteString("Cache-Control: private, max-age=0, no-cache, must-revalidate\r\n")
+	headers.WriteString("ETag: \"5d8c72a5edda8d6a4c7e3f2b1a0f9e8d\"\r\n")
+	headers.WriteString("Last-Modified: Wed, 21 Oct 2015 07:28:00 GMT\r\n")
+	headers.WriteString("Vary: Accept-Encoding, Origin, Authorization\r\n")
+	headers.WriteString("Strict-Transport-Security: max-age=63072000; includeSubDomains; preload\r\n")
+	headers.WriteString("Content-Security-Policy: default-src 'self'")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&headers, "; script-src-elem 'self' https://cdn%d.example.com 'nonce-%032d'", i, i)
+	}
+	headers.WriteString("\r\n")
+	headers.WriteString("X-Content-Type-Options: nosniff\r\n")
+	headers.WriteString("X-Frame-Options: DENY\r\n")
+	headers.WriteString("Referrer-Policy: strict-origin-when-cross-origin\r\n")
+	headers.WriteString("Permissions-Policy: geolocation=(), microphone=(), camera=(), payment=()\r\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&headers, "Set-Cookie: session_%d=%s; Path=/; Domain=example.com; Secure; HttpOnly; SameSite=Lax\r\n", i, makePayload(256))
+	}
+	headers.WriteString("Server-Timing: db;dur=53, app;dur=47.2, cache;desc=\"Cache Read\";dur=23.2\r\n")
+	headers.WriteString("X-Request-Id: 6f1c2b9e-3d4a-4e5f-8a7b-9c0d1e2f3a4b\r\n")
+	headers.WriteString(deepHeaderName + ": " + deepHeaderValue + "\r\n")
+	return headers.String()
+}
+
+// gzipBody compresses the body as a server would before sending it
+func gzipBody(tb testing.TB, body []byte) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(body); err != nil {
+		tb.Fatalf("cannot compress body: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		tb.Fatalf("cannot compress body: %s", err)
+	}
+	return compressed.Bytes()
+}
+
+// makeGzippedResponse builds a gzip-encoded response with a large header block
+func makeGzippedResponse(tb testing.TB, body []byte) []byte {
+	compressed := gzipBody(tb, body)
+	response := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		makeLargeHeaderBlock() +
+		"Content-Length: " + strconv.Itoa(len(compressed)) + "\r\n\r\n"
+	return append([]byte(response), compressed...)
+}
+
+// makeGzippedMockResponse builds a gzip-encoded response with only the usual headers,
+// compressed ahead of time so that a benchmark measures decompression alone
+func makeGzippedMockResponse(tb testing.TB, body []byte) []byte {
+	compressed := gzipBody(tb, body)
+	response := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		"Content-Length: " + strconv.Itoa(len(compressed)) + "\r\n\r\n"
+	return append([]byte(response), compressed...)
+}
+
+// makeJsonDocument generates a JSON array that compresses about as well as a
+// typical API response
+func makeJsonDocument(size int) []byte {
+	document := []byte("[")
+	for i := 0; len(document) < size; i++ {
+		if i > 0 {
+			document = append(document, ',')
+		}
+		document = append(document, fmt.Sprintf("{\"id\":%d,\"name\":\"item-%d\",\"active\":%t}", i, i, i%3 == 0)...)
+	}
+	return append(document, ']')
+}
+
+// reportPhases reports how long each part of a response took on average
+func reportPhases(b *testing.B, headerPhase string, headerTime time.Duration, bodyPhase string, bodyTime time.Duration) {
+	b.ReportMetric(float64(headerTime.Nanoseconds())/float64(b.N), headerPhase+"-ns/op")
+	b.ReportMetric(float64(bodyTime.Nanoseconds())/float64(b.N), bodyPhase+"-ns/op")
+}
+
+func BenchmarkClientGzipWithLargeHeaders(b *testing.B) {
+	testValue := makeJsonDocument(32 << 10)
+	response := makeGzippedResponse(b, testValue)
+	testUrl := "http://host.test/items"
+
+	b.Run("NetHttp", func(b *testing.B) {
+		dial := dialMockServerWithResponse(response)
+		// Create an http.Client
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return dial(addr)
+				},
+			},
+		}
+
+		var headerTime, bodyTime time.Duration
+		b.SetBytes(int64(len(testValue)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// The transport asks for gzip itself, so it also decompresses transparently
+			start := time.Now()
+			resp, err := client.Get(testUrl)
+			if err != nil {
+				b.Fatalf("client get failed: %s", err)
+			}
+			headersParsed := time.Now()
+			// Read the response body
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				b.Fatalf("error while reading response body: %s", err)
+			}
+			headerTime += headersParsed.Sub(start)
+			bodyTime += time.Since(headersParsed)
+
+			if resp.StatusCode != http.StatusOK {
+				b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if value := resp.Header.Get(deepHeaderName); value != deepHeaderValue {
+				b.Fatalf("expected header %s to be %q but got %q", deepHeaderName, deepHeaderValue, value)
+			}
+			if !resp.Uncompressed {
+				b.Fatalf("expected the transport to decompress the body")
+			}
+			if !bytes.Equal(body, testValue) {
+				b.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+			}
+		}
+
+		// Reading the body includes decompressing it
+		reportPhases(b, "headers", headerTime, "read+gunzip", bodyTime)
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		// Create a client
+		client := &fasthttp.Client{
+			Dial: dialMockServerWithResponse(response),
+			// The whole header block has to fit in the read buffer, which is 4KB by
+			// default, or the response fails with ErrSmallBuffer
+			ReadBufferSize: 16 << 10,
+		}
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(testUrl)
+		req.Header.Set(fasthttp.HeaderAcceptEncoding, "gzip")
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		var body []byte
+		var headerTime, bodyTime time.Duration
+		b.SetBytes(int64(len(testValue)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			start := time.Now()
+			err := client.Do(req, resp)
+			if err != nil {
+				b.Fatalf("client get failed: %s", err)
+			}
+			responseRead := time.Now()
+			// fasthttp leaves the body compressed, so decompress it into a reused buffer
+			if !bytes.Equal(resp.Header.ContentEncoding(), []byte("gzip")) {
+				b.Fatalf("expected a gzip-encoded body but got %q", resp.Header.ContentEncoding())
+			}
+			body, err = fasthttp.AppendGunzipBytes(body[:0], resp.Body())
+			if err != nil {
+				b.Fatalf("cannot decompress response body: %s", err)
+			}
+			headerTime += responseRead.Sub(start)
+			bodyTime += time.Since(responseRead)
+
+			if resp.StatusCode() != fasthttp.StatusOK {
+				b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			}
+			if value := string(resp.Header.Peek(deepHeaderName)); value != deepHeaderValue {
+				b.Fatalf("expected header %s to be %q but got %q", deepHeaderName, deepHeaderValue, value)
+			}
+			if !bytes.Equal(body, testValue) {
+				b.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+			}
+		}
+
+		// The compressed body is read along with the headers
+		reportPhases(b, "headers+read", headerTime, "gunzip", bodyTime)
+	})
+}
+
+func BenchmarkNetHttpClientGzipToMockServer(b *testing.B) {
+	// The largest body decompresses to enough memory that allocating it dominates
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makeJsonDocument(bodySize)
+		response := makeGzippedMockResponse(b, testValue)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			dial := dialMockServerWithResponse(response)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testUrl := "http://host.test/items"
+			b.SetBytes(int64(len(testValue)))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// The transport asks for gzip itself, so it also decompresses transparently
+				return netHttpResult(client.Get(testUrl))
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+func BenchmarkFastHttpClientGzipToMockServer(b *testing.B) {
+	// The largest body decompresses to enough memory that allocating it dominates
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makeJsonDocument(bodySize)
+		response := makeGzippedMockResponse(b, testValue)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithResponse(response),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://host.test/items"
+			b.SetBytes(int64(len(testValue)))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Acquire a request instance
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				// fasthttp does not automatically request a gzipped response
+				req.Header.Set(fasthttp.HeaderAcceptEncoding, "gzip")
+
+				// Acquire a response instance
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				if err := client.Do(req, resp); err != nil {
+					return 0, nil, err
+				}
+				if !bytes.Equal(resp.Header.ContentEncoding(), []byte("gzip")) {
+					return 0, nil, fmt.Errorf("expected a gzip-encoded body but got %q", resp.Header.ContentEncoding())
+				}
+				// BodyGunzip decompresses into a new slice on every call
+				body, err := resp.BodyGunzip()
+				return resp.StatusCode(), body, err
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+// The gzip levels that BenchmarkClientGzipCompressionLevels compares, from the
+// fastest to the smallest output
+var gzipCompressionLevels = []struct {
+	name  string
+	level int
+}{
+	{"BestSpeed", fasthttp.CompressBestSpeed},
+	{"DefaultCompression", fasthttp.CompressDefaultCompression},
+	{"BestCompression", fasthttp.CompressBestCompression},
+}
+
+// BenchmarkClientGzipCompressionLevels compresses a request body at each gzip level,
+// as a client would before uploading it with Content-Encoding: gzip. ns/op is the CPU
+// that each level costs on the hot path, and compressed-B and ratio-% what it saves
+// on the wire.
+func BenchmarkClientGzipCompressionLevels(b *testing.B) {
+	body := makeJsonDocument(64 << 10)
+
+	for _, level := range gzipCompressionLevels {
+		b.Run(level.name, func(b *testing.B) {
+			compressed := fasthttp.AppendGzipBytesLevel(nil, body, level.level)
+			decompressed, err := fasthttp.AppendGunzipBytes(nil, compressed)
+			if err != nil {
+				b.Fatalf("cannot decompress body: %s", err)
+			}
+			if !bytes.Equal(decompressed, body) {
+				b.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(body), len(decompressed))
+			}
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Append to the output of the previous iteration to reuse its memory
+				compressed = fasthttp.AppendGzipBytesLevel(compressed[:0], body, level.level)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(len(compressed)), "compressed-B")
+			b.ReportMetric(100*float64(len(compressed))/float64(len(body)), "ratio-%")
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of headers in the response that the lookups search
+const lookupResponseHeaders = 30
+
+// makeLookupResponseHeader parses a response header with many headers, as a client
+// would receive it
+func makeLookupResponseHeader(tb testing.TB) *fasthttp.ResponseHeader {
+	var raw strings.Builder
+	raw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 3\r\n")
+	for i := 0; i < lookupResponseHeaders; i++ {
+		fmt.Fprintf(&raw, "X-Header-%02d: value-%02d\r\n", i, i)
+	}
+	raw.WriteString("\r\n")
+
+	var header fasthttp.ResponseHeader
+	if err := header.Read(bufio.NewReader(strings.NewReader(raw.String()))); err != nil {
+		tb.Fatalf("cannot parse response header: %s", err)
+	}
+	return &header
+}
+
+// lookupHeaderNames picks the given number of headers to read, spread evenly from the
+// start of the response to its last header, and returns their expected values
+func lookupHeaderNames(count int) (names, values [][]byte) {
+	for i := 0; i < count; i++ {
+		position := (i + 1) * lookupResponseHeaders / count
+		names = append(names, []byte(fmt.Sprintf("X-Header-%02d", position-1)))
+		values = append(values, []byte(fmt.Sprintf("value-%02d", position-1)))
+	}
+	return names, values
+}
+
+// lookupHeadersWithPeek reads each header with its own scan of the headers
+func lookupHeadersWithPeek(header *fasthttp.ResponseHeader, names, values [][]byte) {
+	for i, name := range names {
+		values[i] = header.PeekBytes(name)
+	}
+}
+
+// lookupHeadersWithVisitAll reads every header in a single scan. VisitAll has no way
+// to stop early, so once every header has been found, the rest are skipped over
+// without being compared.
+func lookupHeadersWithVisitAll(header *fasthttp.ResponseHeader, names, values [][]byte) {
+	for i := range values {
+		values[i] = nil
+	}
+	found := 0
+	header.VisitAll(func(key, value []byte) {
+		if found == len(names) {
+			return
+		}
+		for i, name := range names {
+			if values[i] == nil && bytes.Equal(key, name) {
+				values[i] = value
+				found++
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkFastHttpClientHeaderLookupStrategies(b *testing.B) {
+	header := makeLookupResponseHeader(b)
+
+	strategies := []struct {
+		name   string
+		lookup func(header *fasthttp.ResponseHeader, names, values [][]byte)
+	}{
+		{"Peek", lookupHeadersWithPeek},
+		{"VisitAll", lookupHeadersWithVisitAll},
+	}
+
+	for _, count := range []int{3, 10} {
+		names, expected := lookupHeaderNames(count)
+
+		for _, strategy := range strategies {
+			b.Run(fmt.Sprintf("%s/headers=%d", strategy.name, count), func(b *testing.B) {
+				values := make([][]byte, count)
+				strategy.lookup(header, names, values)
+				for i := range names {
+					if !bytes.Equal(values[i], expected[i]) {
+						b.Fatalf("expected header %s to be %q but got %q", names[i], expected[i], values[i])
+					}
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					strategy.lookup(header, names, values)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of requests that the headline comparison makes for each client, so that
+// every run measures the same amount of work
+const headlineIterations = 1000000
+
+// requireHeadlineIterations skips the benchmark unless -benchtime asks for exactly
+// headlineIterations. It reads the flag rather than b.N, which is only 1 on the first
+// run of every benchmark, so that it skips before any results are reported.
+func requireHeadlineIterations(b *testing.B) {
+	benchtime := fmt.Sprintf("%dx", headlineIterations)
+	if f := flag.Lookup("test.benchtime"); f == nil || f.Value.String() != benchtime {
+		b.Skipf("the headline comparison only reports results for -benchtime=%s", benchtime)
+	}
+}
+
+// warmClientPool makes as many requests at once as the benchmark runs goroutines, so
+// that the client's pool already holds its connections when the timer starts
+func warmClientPool(b *testing.B, doRequest func() (statusCode int, body []byte, err error), expected []byte) {
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(-1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusCode, body, err := doRequest()
+			checkClientResponse(b, statusCode, body, err, expected)
+		}()
+	}
+	wg.Wait()
+}
+
+// reportHeadlineMetrics reports the rate of requests as req/s, and the connections
+// dialed while the timer ran as dials/op, which is close to 0 for a warm pool
+func reportHeadlineMetrics(b *testing.B, dialer *CountingDialer) {
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+	b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+}
+
+// BenchmarkHeadlineComparison is the one comparison to cite. Both clients make the
+// same GET request to a MockConn that answers it without any latency, so nothing but
+// the clients is measured, and each is set up the way it's meant to be used:
+//   - Both keep an idle connection for every goroutine, and their pools are warmed
+//     up before the timer starts.
+//   - net/http reuses one request, and reads each body into a buffer of its own. It
+//     doesn't ask for gzip, since fasthttp doesn't and the mock never compresses.
+//   - fasthttp creates its request and response once per goroutine and reuses them.
+//
+// It's skipped unless it runs for a fixed number of iterations, so that the results
+// are comparable between runs:
+//
+//	go test -run xxx -bench HeadlineComparison -benchmem -benchtime=1000000x
+func BenchmarkHeadlineComparison(b *testing.B) {
+	requireHeadlineIterations(b)
+	testValue := []byte("123")
+	testUrl := "http://host.test/query"
+
+	b.Run("NetHttp", func(b *testing.B) {
+		dialer := NewCountingDialer(dialMockServer)
+		// Create an http.Client
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return dialer.Dial(addr)
+				},
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				DisableCompression:  true,
+			},
+		}
+
+		warmClientPool(b, func() (int, []byte, error) {
+			return netHttpResult(client.Get(testUrl))
+		}, testValue)
+		dialer.Reset()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+			req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+			if err != nil {
+				b.Fatalf("cannot create request: %s", err)
+			}
+			var body bytes.Buffer
+
+			return func(buffer []byte) (int, []byte, error) {
+				resp, err := client.Do(req)
+				if err != nil {
+					return 0, nil, err
+				}
+				// Read the response body
+				body.Reset()
+				_, err = body.ReadFrom(resp.Body)
+				resp.Body.Close()
+				return resp.StatusCode, body.Bytes(), err
+			}
+		}, testValue)
+		reportHeadlineMetrics(b, dialer)
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		dialer := NewCountingDialer(dialMockServer)
+		// Create a fasthttp.Client
+		client := &fasthttp.Client{
+			Dial: dialer.Dial,
+			// Set the maximum number of connections equal to the max number of processes
+			MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+		}
+
+		warmClientPool(b, func() (int, []byte, error) {
+			return client.Get(nil, testUrl)
+		}, testValue)
+		dialer.Reset()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+			// Create a request and a response once, and reuse them for every request
+			req := &fasthttp.Request{}
+			req.SetRequestURI(testUrl)
+			resp := &fasthttp.Response{}
+
+			return func(buffer []byte) (int, []byte, error) {
+				err := client.Do(req, resp)
+				return resp.StatusCode(), resp.Body(), err
+			}
+		}, testValue)
+		reportHeadlineMetrics(b, dialer)
+	})
+}
@@ -0,0 +1,210 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// How long a health check waits for a pooled connection to report that it's closed.
+// An idle connection that is alive has nothing to read, so every check of one lasts
+// this long.
+const healthCheckTimeout = 50 * time.Microsecond
+
+// The number of requests that the mock server answers on a connection before closing
+// it, which leaves a dead connection in the client's pool
+const mockRequestsPerConnection = 10
+
+// PooledConn is a connection in a HealthCheckedPool, with the buffers that requests
+// and responses are written and read through
+type PooledConn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// HealthCheckedPool keeps the idle connections of a client that makes one request at
+// a time. If healthCheck is set, each connection is checked before it's reused, and a
+// dead one is replaced; otherwise a request on a dead connection fails and is retried
+// on a new one.
+type HealthCheckedPool struct {
+	dial        fasthttp.DialFunc
+	healthCheck bool
+	idle        []*PooledConn
+
+	healthChecks      int64
+	deadConns         int64
+	healthCheckTime   time.Duration
+	failedRequests    int64
+	failedRequestTime time.Duration
+}
+
+// get returns an idle connection, or dials a new one if there are none left that are
+// fit to reuse
+func (p *HealthCheckedPool) get() (conn *PooledConn, reused bool, err error) {
+	for len(p.idle) > 0 {
+		conn = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !p.healthCheck || p.isAlive(conn) {
+			return conn, true, nil
+		}
+		conn.Close()
+	}
+
+	c, err := p.dial("host.test")
+	if err != nil {
+		return nil, false, err
+	}
+	return &PooledConn{Conn: c, reader: bufio.NewReader(c), writer: bufio.NewWriter(c)}, false, nil
+}
+
+// isAlive reads from the connection with a short deadline. Finding nothing to read
+// before the deadline means the connection is still open, while an EOF means the
+// server has closed it, and anything else is unexpected on an idle connection.
+func (p *HealthCheckedPool) isAlive(conn *PooledConn) bool {
+	start := time.Now()
+	defer func() {
+		p.healthCheckTime += time.Since(start)
+	}()
+	p.healthChecks++
+
+	if conn.reader.Buffered() > 0 {
+		p.deadConns++
+		return false
+	}
+	conn.SetReadDeadline(start.Add(healthCheckTimeout))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	p.deadConns++
+	return false
+}
+
+// Do makes the request, retrying it once on a new connection if it fails on one from
+// the pool
+func (p *HealthCheckedPool) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	for {
+		conn, reused, err := p.get()
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		err = req.Write(conn.writer)
+		if err == nil {
+			err = conn.writer.Flush()
+		}
+		if err == nil {
+			err = resp.Read(conn.reader)
+		}
+		if err == nil {
+			p.idle = append(p.idle, conn)
+			return nil
+		}
+		conn.Close()
+		if !reused {
+			return err
+		}
+		p.failedRequests++
+		p.failedRequestTime += time.Since(start)
+	}
+}
+
+func (p *HealthCheckedPool) Close() {
+	for _, conn := range p.idle {
+		conn.Close()
+	}
+	p.idle = nil
+}
+
+// dialMockServerWithMaxResponses returns a dial function whose connections are closed
+// by the server after the given number of responses
+func dialMockServerWithMaxResponses(maxResponses int) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn := mockServerConnectionPool.Get().(*MockConn)
+		conn.maxResponses = maxResponses
+		return conn, nil
+	}
+}
+
+// BenchmarkClientConnectionHealthCheck reuses pooled connections to a server that
+// closes each one after mockRequestsPerConnection requests, either blindly or after
+// checking that the connection is still alive. Blind reuse sends one request in
+// every mockRequestsPerConnection on a dead connection, where it fails and has to be
+// retried. Checking avoids every failure, but a read can only tell that a connection
+// is alive by waiting for its deadline, so each reuse costs healthCheckTimeout, or
+// longer where timers are coarser than that.
+//
+// health-check-ns/op and failed-request-ns/op are the time each approach loses per
+// request. Against the mock, a failed request costs no more than writing it, whereas
+// over a real network it costs a round trip, or a timeout if the server vanished
+// without closing the connection, so blind reuse looks cheaper here than it would be.
+func BenchmarkClientConnectionHealthCheck(b *testing.B) {
+	testValue := "123"
+
+	for _, healthCheck := range []bool{false, true} {
+		name := "blind-reuse"
+		if healthCheck {
+			name = "health-checked"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			dialer := NewCountingDialer(dialMockServerWithMaxResponses(mockRequestsPerConnection))
+			// Create a pool of connections
+			pool := &HealthCheckedPool{
+				dial:        dialer.Dial,
+				healthCheck: healthCheck,
+			}
+			defer pool.Close()
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("http://host.test/query")
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := pool.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				if string(resp.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+				}
+			}
+			b.StopTimer()
+
+			if healthCheck && pool.failedRequests != 0 {
+				b.Fatalf("expected health checks to avoid every failed request but %d failed", pool.failedRequests)
+			}
+			if b.N > mockRequestsPerConnection {
+				deadConns := pool.deadConns
+				if !healthCheck {
+					deadConns = pool.failedRequests
+				}
+				if deadConns == 0 {
+					b.Fatalf("expected the server to have closed some of the pooled connections")
+				}
+			}
+
+			b.ReportMetric(float64(pool.failedRequests)/float64(b.N), "failed-requests/op")
+			b.ReportMetric(float64(pool.failedRequestTime.Nanoseconds())/float64(b.N), "failed-request-ns/op")
+			b.ReportMetric(float64(pool.healthCheckTime.Nanoseconds())/float64(b.N), "health-check-ns/op")
+			b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// Response bodies for comparing the protocols, from one that fits in a single HTTP/2
+// frame to one that takes several
+var http2ResponseBodySizes = []int{1 << 10, 64 << 10}
+
+// handleNetHttpFixedBodyRequest returns a net/http handler that always responds with
+// the given body
+func handleNetHttpFixedBodyRequest(body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// startHttp2Server serves HTTPS with a net/http server, which speaks HTTP/2 to clients
+// that ask for it while negotiating TLS and HTTP/1.1 to the rest. Rather than leaving
+// TLS to ServeTLS, it wraps the usual listener in a TLS listener, and sets HTTP/2 up
+// with http2.ConfigureServer.
+func startHttp2Server(tb testing.TB, handler http.Handler) *TcpServer {
+	server := &http.Server{
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{getTestCertificate(tb)},
+			NextProtos:   []string{"h2", "http/1.1"},
+		},
+		// Clients closing their connections when the benchmark ends isn't worth logging
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	// Register the HTTP/2 handler for connections that negotiate h2
+	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+		tb.Fatalf("cannot configure HTTP/2: %s", err)
+	}
+
+	tlsListener := tls.NewListener(listenTcp(tb), server.TLSConfig)
+	return startServerOnListener(tb, tlsListener, serveNetHttp(server.Serve))
+}
+
+// BenchmarkClientOverTLSToNetHttpHttp2Server compares net/http's HTTP/2 client with
+// fasthttp's HTTP/1.1 client, both talking to the same net/http server over TLS. The
+// comparison crosses protocol versions by design, since fasthttp doesn't speak HTTP/2
+// and choosing it means giving HTTP/2 up. net/http over HTTP/1.1 to the same server
+// is included so that the difference between the protocols can be told apart from
+// the difference between the clients.
+//
+// Over HTTP/2, net/http multiplexes every request onto a single connection instead
+// of keeping one for each process, and frames each response, which copies its body
+// through the connection's flow control. The server runs in the same process, so
+// allocs/op includes its allocations too, which is why fasthttp's aren't zero here.
+func BenchmarkClientOverTLSToNetHttpHttp2Server(b *testing.B) {
+	for _, bodySize := range http2ResponseBodySizes {
+		testValue := makePayload(bodySize)
+		sizeName := fmt.Sprintf("size=%dKB", bodySize>>10)
+
+		for _, useHttp2 := range []bool{true, false} {
+			protocolName, protoMajor := "http1.1", 1
+			if useHttp2 {
+				protocolName, protoMajor = "h2", 2
+			}
+
+			b.Run("NetHttp/"+protocolName+"/"+sizeName, func(b *testing.B) {
+				// Start a server
+				server := startHttp2Server(b, handleNetHttpFixedBodyRequest(testValue))
+				defer server.Stop(b)
+
+				// Create an http.Client
+				transport := &http.Transport{
+					TLSClientConfig: newClientTlsConfig(),
+					// A custom TLSClientConfig turns HTTP/2 off unless it's asked for
+					ForceAttemptHTTP2: useHttp2,
+					// Set the maximum number of idle connections equal to the current max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+				defer transport.CloseIdleConnections()
+				client := &http.Client{
+					Transport: transport,
+				}
+
+				testUrl := "https://" + server.hostAddress + "/query"
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					resp, err := client.Get(testUrl)
+					if err == nil && resp.ProtoMajor != protoMajor {
+						resp.Body.Close()
+						return 0, nil, fmt.Errorf("expected HTTP/%d but got %s", protoMajor, resp.Proto)
+					}
+					return netHttpResult(resp, err)
+				}, testValue)
+			})
+		}
+
+		b.Run("FastHttp/http1.1/"+sizeName, func(b *testing.B) {
+			// Start a server
+			server := startHttp2Server(b, handleNetHttpFixedBodyRequest(testValue))
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				TLSConfig: newClientTlsConfig(),
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+			defer client.CloseIdleConnections()
+
+			testUrl := "https://" + server.hostAddress + "/query"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
+			}, testValue)
+		})
+	}
+}
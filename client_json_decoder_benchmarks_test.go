@@ -0,0 +1,193 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of objects in the NDJSON stream served by the mock
+const numberOfStreamedObjects = 1000
+
+// StreamedObject is one line of the NDJSON stream, like an event from a change
+// data capture feed or a log shipper
+type StreamedObject struct {
+	ID    int      `json:"id"`
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Score float64  `json:"score"`
+}
+
+// makeNdjsonStream generates one object per line
+func makeNdjsonStream(count int) []byte {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for i := 0; i < count; i++ {
+		encoder.Encode(StreamedObject{
+			ID:    i,
+			Name:  "object-" + strconv.Itoa(i),
+			Tags:  []string{"ingest", "stream"},
+			Score: float64(i) * 1.5,
+		})
+	}
+	return buffer.Bytes()
+}
+
+// ObjectVerifier checks that the objects of a stream arrive in order and intact
+type ObjectVerifier struct {
+	count int
+}
+
+func (v *ObjectVerifier) Verify(object *StreamedObject) error {
+	if object.ID != v.count || object.Name != "object-"+strconv.Itoa(v.count) || object.Score != float64(v.count)*1.5 || len(object.Tags) != 2 {
+		return fmt.Errorf("object %d decoded incorrectly: %+v", v.count, *object)
+	}
+	v.count++
+	return nil
+}
+
+func (v *ObjectVerifier) Done() error {
+	if v.count != numberOfStreamedObjects {
+		return fmt.Errorf("expected %d objects but decoded %d", numberOfStreamedObjects, v.count)
+	}
+	return nil
+}
+
+// decodeWithReusedDecoder decodes the whole stream with a single json.Decoder
+func decodeWithReusedDecoder(r io.Reader) error {
+	var verifier ObjectVerifier
+	decoder := json.NewDecoder(r)
+	for {
+		var object StreamedObject
+		if err := decoder.Decode(&object); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("cannot decode object %d: %s", verifier.count, err)
+		}
+		if err := verifier.Verify(&object); err != nil {
+			return err
+		}
+	}
+	return verifier.Done()
+}
+
+// decodeWithDecoderPerObject splits the stream into lines and creates a new
+// json.Decoder for each
+func decodeWithDecoderPerObject(r *bufio.Reader) error {
+	var verifier ObjectVerifier
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return fmt.Errorf("cannot read object %d: %s", verifier.count, err)
+		}
+
+		var object StreamedObject
+		if err := json.NewDecoder(bytes.NewReader(line)).Decode(&object); err != nil {
+			return fmt.Errorf("cannot decode object %d: %s", verifier.count, err)
+		}
+		if err := verifier.Verify(&object); err != nil {
+			return err
+		}
+	}
+	return verifier.Done()
+}
+
+func BenchmarkClientReusedJsonDecoder(b *testing.B) {
+	stream := makeNdjsonStream(numberOfStreamedObjects)
+	response := makeMockResponse("application/x-ndjson", stream)
+	testUrl := "http://host.test/events"
+
+	for _, reuse := range []bool{true, false} {
+		name := "decoder-per-object"
+		if reuse {
+			name = "reused-decoder"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			dial := dialMockServerWithResponse(response)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+				},
+			}
+
+			lineReader := bufio.NewReader(nil)
+			b.SetBytes(int64(len(stream)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+				// Decode straight from the response body
+				if reuse {
+					err = decodeWithReusedDecoder(resp.Body)
+				} else {
+					lineReader.Reset(resp.Body)
+					err = decodeWithDecoderPerObject(lineReader)
+				}
+				resp.Body.Close()
+				if err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithResponse(response),
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			bodyReader := bytes.NewReader(nil)
+			lineReader := bufio.NewReader(nil)
+			b.SetBytes(int64(len(stream)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				// The body is already buffered, so decode from memory
+				bodyReader.Reset(resp.Body())
+				if reuse {
+					err = decodeWithReusedDecoder(bodyReader)
+				} else {
+					lineReader.Reset(bodyReader)
+					err = decodeWithDecoderPerObject(lineReader)
+				}
+				if err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+		})
+	}
+}
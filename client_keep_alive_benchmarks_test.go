@@ -0,0 +1,214 @@
+package fasthttp_request_perf
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// A response from a server that closes every connection once it has responded, as
+// many load balancers do
+var mockClosingResponseData = []byte("HTTP/1.1 200 OK\r\nContent-Type: test/plain\r\nConnection: close\r\nContent-Length: 3\r\n\r\n123")
+
+// CloseCountingConn counts how many times the client closes a MockConn
+type CloseCountingConn struct {
+	*MockConn
+	closes *int64
+}
+
+func (c CloseCountingConn) Close() error {
+	atomic.AddInt64(c.closes, 1)
+	return c.MockConn.Close()
+}
+
+// dialRecycledMockServer returns a dial function that checks that every MockConn it
+// hands out, whether new or recycled from the pool, is ready for a new request
+func dialRecycledMockServer(t *testing.T, closes *int64, conns map[*MockConn]bool) fasthttp.DialFunc {
+	dial := dialMockServerWithResponse(mockClosingResponseData)
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		mockConn := conn.(*MockConn)
+		if mockConn.numberOfBytesRead != 0 || len(mockConn.requestHeaders) != 0 || mockConn.isReadingRequestBody || len(mockConn.hasBeenRequested) != 0 || mockConn.responses != 0 {
+			t.Errorf("expected a MockConn with no request or response in progress")
+		}
+		conns[mockConn] = true
+		return CloseCountingConn{MockConn: mockConn, closes: closes}, nil
+	}
+}
+
+// expectClosedConnections waits for the client to close every connection it dialed.
+// net/http closes connections from a background goroutine, so the last one may be
+// closed just after its response has been returned.
+func expectClosedConnections(tb testing.TB, dialer *CountingDialer, closes *int64) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(closes) != dialer.Count() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt64(closes) != dialer.Count() {
+		tb.Fatalf("expected all %d connections to be closed but got %d", dialer.Count(), atomic.LoadInt64(closes))
+	}
+}
+
+// expectRecycledConnections checks that fewer MockConns served the requests than there
+// were requests, which they couldn't have unless closing one put it back in the pool
+func expectRecycledConnections(t *testing.T, requests int, conns map[*MockConn]bool) {
+	t.Logf("%d requests were served by %d MockConns", requests, len(conns))
+	if raceEnabled {
+		// The race detector makes sync.Pool drop items at random
+		return
+	}
+	if len(conns) >= requests {
+		t.Fatalf("expected closed MockConns to be reused but each of the %d requests got a new one", requests)
+	}
+}
+
+// TestMockConnRecyclesClosedConnections makes sequential requests to a mock server that
+// closes each connection after responding, so that every request takes a MockConn
+// that an earlier one put back in the pool
+func TestMockConnRecyclesClosedConnections(t *testing.T) {
+	testValue := "123"
+	testUrl := "http://host.test/query"
+	requests := 50
+
+	t.Run("NetHttp", func(t *testing.T) {
+		var closes int64
+		conns := make(map[*MockConn]bool)
+		dialer := NewCountingDialer(dialRecycledMockServer(t, &closes, conns))
+		// Create an http.Client, which keeps connections alive unless told not to
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: dialer.DialNetwork,
+			},
+		}
+
+		for i := 0; i < requests; i++ {
+			statusCode, body, err := netHttpResult(client.Get(testUrl))
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != http.StatusOK {
+				t.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		}
+
+		if dialer.Count() != int64(requests) {
+			t.Fatalf("expected a new connection for each of the %d requests but got %d", requests, dialer.Count())
+		}
+		expectClosedConnections(t, dialer, &closes)
+		expectRecycledConnections(t, requests, conns)
+	})
+
+	t.Run("FastHttp", func(t *testing.T) {
+		var closes int64
+		conns := make(map[*MockConn]bool)
+		dialer := NewCountingDialer(dialRecycledMockServer(t, &closes, conns))
+		// Create a client, which keeps connections alive unless the server closes them
+		client := &fasthttp.Client{
+			Dial: dialer.Dial,
+		}
+
+		var buffer []byte
+		for i := 0; i < requests; i++ {
+			statusCode, body, err := client.Get(buffer, testUrl)
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+			buffer = body
+		}
+
+		if dialer.Count() != int64(requests) {
+			t.Fatalf("expected a new connection for each of the %d requests but got %d", requests, dialer.Count())
+		}
+		expectClosedConnections(t, dialer, &closes)
+		expectRecycledConnections(t, requests, conns)
+	})
+}
+
+// BenchmarkClientKeepAlive compares reusing connections with establishing a new one
+// for every request, as a client behind a load balancer that closes connections does
+func BenchmarkClientKeepAlive(b *testing.B) {
+	testValue := []byte("123")
+
+	for _, keepAlive := range []bool{true, false} {
+		name := "keep-alive"
+		if !keepAlive {
+			name = "close"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServer(b)
+			defer server.Stop(b)
+
+			dialer := NewCountingDialer(fasthttp.Dial)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial:              dialer.DialNetwork,
+					DisableKeepAlives: !keepAlive,
+					// Set the maximum number of idle connections equal to the current max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testUrl := "http://" + server.hostAddress + "/query?q=" + string(testValue)
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			}, testValue)
+
+			b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServer(b)
+			defer server.Stop(b)
+
+			dialer := NewCountingDialer(fasthttp.Dial)
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				Dial: dialer.Dial,
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://" + server.hostAddress + "/query?q=" + string(testValue)
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				if !keepAlive {
+					// fasthttp has no option to disable keep-alive for a whole client
+					req.SetConnectionClose()
+				}
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				err := client.Do(req, resp)
+				return resp.StatusCode(), append(buffer[:0], resp.Body()...), err
+			}, testValue)
+
+			b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+		})
+	}
+}
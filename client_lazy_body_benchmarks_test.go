@@ -0,0 +1,226 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// writeRecords computes a newline-delimited JSON body one record at a time, as a
+// client exporting rows from a database or generating a report would
+func writeRecords(w io.Writer, count int) error {
+	record := make([]byte, 0, 128)
+	for i := 0; i < count; i++ {
+		record = append(record[:0], "{\"id\":"...)
+		record = strconv.AppendInt(record, int64(i), 10)
+		record = append(record, ",\"value\":\""...)
+		record = strconv.AppendInt(record, int64(i)*int64(i), 16)
+		record = append(record, "\",\"payload\":\"abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz\"}\n"...)
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The number of bytes generated between heap samples
+const heapSampleInterval = 64 << 10
+
+// HeapSamplingWriter samples the heap as the body is generated, so that the peak is
+// taken while the client holds the body, rather than after the request is done
+type HeapSamplingWriter struct {
+	w         io.Writer
+	heap      *HeapSampler
+	b         *testing.B
+	unsampled int
+}
+
+func (w *HeapSamplingWriter) Write(p []byte) (int, error) {
+	w.unsampled += len(p)
+	if w.unsampled >= heapSampleInterval {
+		w.unsampled = 0
+		w.heap.Sample(w.b)
+	}
+	return w.w.Write(p)
+}
+
+// startStreamedUploadServer starts a server that streams each request body through
+// a checksum, so that none of the upload is held on the server side of the heap
+func startStreamedUploadServer(b *testing.B) *TcpServer {
+	return startTcpServerWithServer(b, &fasthttp.Server{
+		Handler:           handleStreamedUploadRequest,
+		StreamRequestBody: true,
+	})
+}
+
+// buildRecords computes the whole body up front
+func buildRecords(count int) []byte {
+	var buffer bytes.Buffer
+	writeRecords(&buffer, count)
+	return buffer.Bytes()
+}
+
+// BenchmarkFastHttpClientLazyBodyGeneration compares computing a request body in
+// full before sending it against generating it while it's written to the wire.
+// A lazily generated body has no known length, so it is sent chunked.
+//
+// peak-heap-B is sampled while each body is generated, and the server streams what
+// it receives, so the peak is what the client holds.
+func BenchmarkFastHttpClientLazyBodyGeneration(b *testing.B) {
+	// About 100KB and 2MB, which stays under the server's default 4MB limit on request bodies
+	for _, recordCount := range []int{1000, 20000} {
+		// The server responds with the length and checksum of what it received, which
+		// must match a body generated in full
+		expectedBody := buildRecords(recordCount)
+		testValue := uploadSummary(expectedBody)
+		bodySize := int64(len(expectedBody))
+
+		b.Run(fmt.Sprintf("FastHttp/prebuilt/records=%d", recordCount), func(b *testing.B) {
+			// Start a server
+			server := startStreamedUploadServer(b)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("http://" + server.hostAddress + "/upload")
+			req.Header.SetMethod(fasthttp.MethodPost)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			heap := NewHeapSampler()
+			b.SetBytes(bodySize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				body := buildRecords(recordCount)
+				heap.Sample(b)
+				req.SetBodyRaw(body)
+
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client post failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				if string(resp.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+				}
+			}
+
+			heap.Report(b)
+		})
+
+		b.Run(fmt.Sprintf("FastHttp/lazy/records=%d", recordCount), func(b *testing.B) {
+			// Start a server
+			server := startStreamedUploadServer(b)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("http://" + server.hostAddress + "/upload")
+			req.Header.SetMethod(fasthttp.MethodPost)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			heap := NewHeapSampler()
+			b.SetBytes(bodySize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// The stream writer is consumed by sending the request, so set it every time.
+				// fasthttp runs it in a goroutine of its own, but reads the body to the end
+				// before Do returns.
+				req.SetBodyStreamWriter(func(w *bufio.Writer) {
+					writeRecords(&HeapSamplingWriter{w: w, heap: heap, b: b}, recordCount)
+				})
+
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client post failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				if string(resp.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+				}
+			}
+
+			heap.Report(b)
+		})
+
+		b.Run(fmt.Sprintf("NetHttp/lazy/records=%d", recordCount), func(b *testing.B) {
+			// Start a server
+			server := startStreamedUploadServer(b)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{},
+			}
+
+			testUrl := "http://" + server.hostAddress + "/upload"
+			heap := NewHeapSampler()
+			b.SetBytes(bodySize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// A goroutine generates the body into a pipe, which the client reads from
+				// as it writes the request. It samples the heap too, so it's waited for
+				// before the benchmark goes on.
+				bodyReader, bodyWriter := io.Pipe()
+				generated := make(chan struct{})
+				go func() {
+					defer close(generated)
+					w := bufio.NewWriter(bodyWriter)
+					err := writeRecords(&HeapSamplingWriter{w: w, heap: heap, b: b}, recordCount)
+					if err == nil {
+						err = w.Flush()
+					}
+					bodyWriter.CloseWithError(err)
+				}()
+
+				req, err := http.NewRequest(http.MethodPost, testUrl, bodyReader)
+				if err != nil {
+					b.Fatalf("cannot create request: %s", err)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					b.Fatalf("client post failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+				// Read the response body
+				respBody, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatalf("error while reading response body: %s", err)
+				}
+				<-generated
+				if string(respBody) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, respBody)
+				}
+			}
+
+			heap.Report(b)
+		})
+	}
+}
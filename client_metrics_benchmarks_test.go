@@ -0,0 +1,239 @@
+package fasthttp_request_perf
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Upper bounds of the request duration histogram buckets. Durations beyond the
+// last bound are counted in an overflow bucket.
+var clientMetricsBucketBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+}
+
+// ClientMetrics mimics the counters a Prometheus-style instrumented client
+// updates on every request. All fields are updated atomically, so the int64
+// fields come first to keep them 64-bit aligned on 32-bit platforms.
+type ClientMetrics struct {
+	requests        int64
+	errors          int64
+	bytesIn         int64
+	bytesOut        int64
+	durationBuckets [len(clientMetricsBucketBounds) + 1]int64
+	durationTotalNs int64
+}
+
+func (m *ClientMetrics) Observe(bytesOut, bytesIn int, duration time.Duration, err error) {
+	atomic.AddInt64(&m.requests, 1)
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+	}
+	atomic.AddInt64(&m.bytesOut, int64(bytesOut))
+	atomic.AddInt64(&m.bytesIn, int64(bytesIn))
+	atomic.AddInt64(&m.durationTotalNs, int64(duration))
+
+	bucket := len(clientMetricsBucketBounds)
+	for i, bound := range clientMetricsBucketBounds {
+		if duration <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&m.durationBuckets[bucket], 1)
+}
+
+// Verify checks that every request was counted and none of them failed, and that the
+// bytes out add up to what was written to the connections
+func (m *ClientMetrics) Verify(b *testing.B, expectedBytesPerRequest int, written *int64) {
+	requests := atomic.LoadInt64(&m.requests)
+	if requests == 0 {
+		b.Fatalf("expected requests to be counted")
+	}
+	if errors := atomic.LoadInt64(&m.errors); errors != 0 {
+		b.Fatalf("expected no errors but counted %d", errors)
+	}
+	if bytesIn := atomic.LoadInt64(&m.bytesIn); bytesIn != requests*int64(expectedBytesPerRequest) {
+		b.Fatalf("expected %d bytes in but counted %d", requests*int64(expectedBytesPerRequest), bytesIn)
+	}
+	if bytesOut := atomic.LoadInt64(&m.bytesOut); bytesOut != atomic.LoadInt64(written) {
+		b.Fatalf("expected %d bytes out but counted %d", atomic.LoadInt64(written), bytesOut)
+	}
+	var observations int64
+	for i := range m.durationBuckets {
+		observations += atomic.LoadInt64(&m.durationBuckets[i])
+	}
+	if observations != requests {
+		b.Fatalf("expected %d duration observations but counted %d", requests, observations)
+	}
+}
+
+// RequestSizeTrace adds up the size of a request as net/http writes it, from the
+// header fields that it reports to the trace
+type RequestSizeTrace struct {
+	headerBytes int
+}
+
+func (t *RequestSizeTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		WroteHeaderField: func(key string, values []string) {
+			// Each value is written on a line of its own
+			for _, value := range values {
+				t.headerBytes += len(key) + len(": ") + len(value) + len("\r\n")
+			}
+		},
+	}
+}
+
+// Size returns the size of the request that was last written, which is its request
+// line, header fields, the blank line after them and its body, and starts counting
+// the next one
+func (t *RequestSizeTrace) Size(req *http.Request) int {
+	requestLine := len(req.Method) + len(" ") + len(req.URL.RequestURI()) + len(" HTTP/1.1\r\n")
+	size := requestLine + t.headerBytes + len("\r\n") + int(req.ContentLength)
+	t.headerBytes = 0
+	return size
+}
+
+// dialMockServerCountingBytes dials a MockConn, and adds the bytes written to it to
+// the counter
+func dialMockServerCountingBytes(written *int64) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dialMockServer(addr)
+		return ByteCountingConn{Conn: conn, written: written}, err
+	}
+}
+
+// BenchmarkClientMetricsInstrumentationOverhead updates the metrics of every request,
+// counting the bytes out as the request's size. Both clients write to connections
+// that count the bytes as well, which the sizes have to add up to.
+func BenchmarkClientMetricsInstrumentationOverhead(b *testing.B) {
+	testValue := "123"
+	testUrl := "http://host.test/query"
+
+	for _, withMetrics := range []bool{false, true} {
+		name := "metrics=off"
+		if withMetrics {
+			name = "metrics=on"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Create an http.Client
+			var written int64
+			dial := dialMockServerCountingBytes(&written)
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+			metrics := &ClientMetrics{}
+
+			b.RunParallel(func(pb *testing.PB) {
+				// The trace is only read once net/http has written the request, which the
+				// MockConn has to receive in full before it responds
+				sizeTrace := &RequestSizeTrace{}
+				ctx := context.Background()
+				if withMetrics {
+					ctx = httptrace.WithClientTrace(ctx, sizeTrace.ClientTrace())
+				}
+				for pb.Next() {
+					var start time.Time
+					if withMetrics {
+						start = time.Now()
+					}
+					req, err := http.NewRequestWithContext(ctx, http.MethodGet, testUrl, nil)
+					if err != nil {
+						b.Fatalf("cannot create request: %s", err)
+					}
+					resp, err := client.Do(req)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if withMetrics {
+						metrics.Observe(sizeTrace.Size(req), len(body), time.Since(start), err)
+					}
+					if string(body) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, body)
+					}
+				}
+			})
+
+			if withMetrics {
+				metrics.Verify(b, len(testValue), &written)
+			}
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Create a fasthttp.Client
+			var written int64
+			client := &fasthttp.Client{
+				Dial: dialMockServerCountingBytes(&written),
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+			metrics := &ClientMetrics{}
+
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				for pb.Next() {
+					var start time.Time
+					if withMetrics {
+						start = time.Now()
+					}
+					err := client.Do(req, resp)
+					if withMetrics {
+						// Sending the request fills in its Host and User-Agent headers, so
+						// the headers are only measured afterwards
+						bytesOut := len(req.Header.Header()) + len(req.Body())
+						metrics.Observe(bytesOut, len(resp.Body()), time.Since(start), err)
+					}
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+				}
+			})
+
+			if withMetrics {
+				metrics.Verify(b, len(testValue), &written)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The prefix of the paths that carry a user-supplied segment
+const escapedPathPrefix = "/files/"
+
+// User-supplied path segments, such as file names or search terms
+var userPathSegments = []string{
+	"report.pdf",
+	"my vacation photo.jpg",
+	"café/naïve résumé",
+	"100% done?#draft",
+	"reserved:@!$&'()*+,;=",
+	"日本語のファイル名",
+}
+
+func handlePathUnescapeRequest(ctx *fasthttp.RequestCtx) {
+	// fasthttp has already unescaped the path, e.g. %20 back to a space
+	path := ctx.Path()
+	if !bytes.HasPrefix(path, []byte(escapedPathPrefix)) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	// Echo the segment so that clients can check that it survived the round trip
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(path[len(escapedPathPrefix):])
+}
+
+// escapedPaths escapes every segment ahead of time
+func escapedPaths(segments []string) []string {
+	paths := make([]string, len(segments))
+	for i, segment := range segments {
+		paths[i] = escapedPathPrefix + url.PathEscape(segment)
+	}
+	return paths
+}
+
+func TestClientPathEscaping(t *testing.T) {
+	// Start a server
+	server := startTcpServerWithHandler(t, handlePathUnescapeRequest)
+	defer server.Stop(t)
+
+	paths := escapedPaths(userPathSegments)
+
+	t.Run("NetHttp", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{},
+		}
+		for i, segment := range userPathSegments {
+			resp, err := client.Get("http://" + server.hostAddress + paths[i])
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("error while reading response body: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if string(body) != segment {
+				t.Fatalf("expected body %q but got %q", segment, body)
+			}
+		}
+	})
+
+	t.Run("FastHttp", func(t *testing.T) {
+		client := &fasthttp.Client{}
+		for i, segment := range userPathSegments {
+			statusCode, body, err := client.Get(nil, "http://"+server.hostAddress+paths[i])
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+			}
+			if string(body) != segment {
+				t.Fatalf("expected body %q but got %q", segment, body)
+			}
+		}
+	})
+}
+
+func BenchmarkClientPathEscaping(b *testing.B) {
+	paths := escapedPaths(userPathSegments)
+
+	// The cost of escaping on its own
+	b.Run("Escape", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			segment := userPathSegments[i%len(userPathSegments)]
+			if escaped := url.PathEscape(segment); len(escaped) < len(segment) {
+				b.Fatalf("expected %q to be escaped but got %q", segment, escaped)
+			}
+		}
+	})
+
+	// The cost of unescaping on the server, calling the handler directly
+	b.Run("Unescape", func(b *testing.B) {
+		var ctx fasthttp.RequestCtx
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			j := i % len(userPathSegments)
+			ctx.Request.Reset()
+			ctx.Response.Reset()
+			ctx.Request.SetRequestURI(paths[j])
+			handlePathUnescapeRequest(&ctx)
+			if string(ctx.Response.Body()) != userPathSegments[j] {
+				b.Fatalf("expected body %q but got %q", userPathSegments[j], ctx.Response.Body())
+			}
+		}
+	})
+
+	for _, escapePerRequest := range []bool{false, true} {
+		name := "pre-escaped"
+		if escapePerRequest {
+			name = "escaped-per-request"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handlePathUnescapeRequest)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			baseUrl := "http://" + server.hostAddress
+			var next uint32
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(atomic.AddUint32(&next, 1)) % len(userPathSegments)
+					testValue := userPathSegments[i]
+					testUrl := baseUrl + paths[i]
+					if escapePerRequest {
+						testUrl = baseUrl + escapedPathPrefix + url.PathEscape(testValue)
+					}
+
+					resp, err := client.Get(testUrl)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if string(body) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, body)
+					}
+				}
+			})
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handlePathUnescapeRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			baseUrl := "http://" + server.hostAddress
+			var next uint32
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				var buffer []byte
+				for pb.Next() {
+					i := int(atomic.AddUint32(&next, 1)) % len(userPathSegments)
+					testValue := userPathSegments[i]
+					testUrl := baseUrl + paths[i]
+					if escapePerRequest {
+						testUrl = baseUrl + escapedPathPrefix + url.PathEscape(testValue)
+					}
+
+					statusCode, body, err := client.Get(buffer, testUrl)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if statusCode != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+					}
+					if string(body) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, body)
+					}
+					buffer = body
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMockConnServesPipelinedRequests(t *testing.T) {
+	conn := NewMockConn(1 << 10)
+	expected := mockResponseWithBodySize(1 << 10)
+
+	// Three requests in a single write, the last of them split across a second one
+	request := []byte("POST /upload HTTP/1.1\r\nHost: host.test\r\nContent-Length: 3\r\n\r\n123")
+	requests := append(append(append([]byte{}, request...), request...), request...)
+	split := len(requests) - len(request)/2
+	for _, b := range [][]byte{requests[:split], requests[split:]} {
+		if _, err := conn.Write(b); err != nil {
+			t.Fatalf("write failed: %s", err)
+		}
+	}
+
+	// The responses come back in order, without waiting for any more requests
+	for i := 0; i < 3; i++ {
+		response := make([]byte, len(expected))
+		for n := 0; n < len(response); {
+			read, err := conn.Read(response[n:])
+			if err != nil {
+				t.Fatalf("read of response %d failed: %s", i, err)
+			}
+			n += read
+		}
+		if !bytes.Equal(response, expected) {
+			t.Fatalf("expected response %d to be %q but got %q", i, expected, response)
+		}
+	}
+	if len(conn.hasBeenRequested) != 0 {
+		t.Fatalf("expected no pending requests but got %d", len(conn.hasBeenRequested))
+	}
+}
+
+// BenchmarkFastHttpPipelineClientToMockServer sends the same load through a
+// PipelineClient, which writes a request without waiting for the responses to the
+// ones before it, and through the non-pipelined fasthttp.Client. Both get one
+// connection for each process, and max-pending goroutines for each of them, so with
+// max-pending=1 the Client is set up exactly as in BenchmarkFastHttpClientToMockServer.
+// Beyond that, requests to the Client wait for a free connection, while the
+// PipelineClient queues up to MaxPendingRequests of them on each connection.
+func BenchmarkFastHttpPipelineClientToMockServer(b *testing.B) {
+	bodySize := 1 << 10
+	testValue := makePayload(bodySize)
+	testUrl := "http://host.test/query"
+
+	for _, maxPending := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("max-pending=%d/Client", maxPending), func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithBodySize(bodySize),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+				// Wait for a connection rather than failing with ErrNoFreeConns
+				MaxConnWaitTimeout: time.Second,
+			}
+
+			b.SetBytes(int64(bodySize))
+			b.SetParallelism(maxPending)
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
+			}, testValue)
+		})
+
+		b.Run(fmt.Sprintf("max-pending=%d/PipelineClient", maxPending), func(b *testing.B) {
+			// Create a pipelining client
+			client := &fasthttp.PipelineClient{
+				Addr: "host.test",
+				Dial: dialMockServerWithBodySize(bodySize),
+				// Open one connection for each process, as the Client does
+				MaxConns: runtime.GOMAXPROCS(-1),
+				// Every goroutine fits in the queue of a connection, so none of them get
+				// ErrPipelineOverflow
+				MaxPendingRequests: maxPending,
+			}
+
+			b.SetBytes(int64(bodySize))
+			b.SetParallelism(maxPending)
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				// Acquire a request instance
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+
+				// Acquire a response instance
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				err := client.Do(req, resp)
+				// The body belongs to the response, which is about to be released, so copy
+				// it into the buffer that the benchmark hands back each time
+				return resp.StatusCode(), append(buffer[:0], resp.Body()...), err
+			}, testValue)
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package fasthttp_request_perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of logical clients, e.g. one per downstream API of a microservice
+const numberOfLogicalClients = 8
+
+// reportConnections reports the total number of connections dialed and how many
+// that comes to per logical client
+func reportConnections(b *testing.B, dialer *CountingDialer) {
+	b.ReportMetric(float64(dialer.Count()), "conns")
+	b.ReportMetric(float64(dialer.Count())/numberOfLogicalClients, "conns/client")
+}
+
+// liveHeap collects garbage and returns the heap that's left in use
+func liveHeap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// reportRetainedHeap reports the heap that the clients still hold once the benchmark
+// is done, which includes their idle connections, as heap-B/client. The heap in use
+// before they were created is the baseline.
+func reportRetainedHeap(b *testing.B, baseline uint64, clients interface{}) {
+	b.StopTimer()
+	retained := int64(liveHeap()) - int64(baseline)
+	runtime.KeepAlive(clients)
+	b.ReportMetric(float64(retained)/numberOfLogicalClients, "heap-B/client")
+}
+
+// dialLogicalApis returns a dial function whose connections answer with the response
+// of the API at the address they're dialed to
+func dialLogicalApis(responses map[string][]byte) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		response, ok := responses[addr]
+		if !ok {
+			return nil, fmt.Errorf("no API at %s", addr)
+		}
+		return dialMockServerWithResponse(response)(addr)
+	}
+}
+
+// BenchmarkSharedVsSeparatePools makes requests through several logical clients,
+// which either share one pool or have one each. Every client talks to an API on a
+// host of its own, whose body is different from the others, so a response that came
+// through another client's connection fails the benchmark.
+func BenchmarkSharedVsSeparatePools(b *testing.B) {
+	testUrls := make([]string, numberOfLogicalClients)
+	testValues := make([]string, numberOfLogicalClients)
+	responses := make(map[string][]byte, numberOfLogicalClients)
+	for i := range testUrls {
+		host := fmt.Sprintf("api%d.test", i)
+		testUrls[i] = "http://" + host + "/query"
+		testValues[i] = fmt.Sprintf("api%d", i)
+		responses[host+":80"] = makeMockResponse("text/plain", []byte(testValues[i]))
+	}
+
+	for _, shared := range []bool{true, false} {
+		name := "separate"
+		if shared {
+			name = "shared"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			baseline := liveHeap()
+			dialer := NewCountingDialer(dialLogicalApis(responses))
+			newTransport := func() *http.Transport {
+				return &http.Transport{
+					Dial: dialer.DialNetwork,
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+			}
+
+			sharedTransport := newTransport()
+			clients := make([]*http.Client, numberOfLogicalClients)
+			for i := range clients {
+				transport := sharedTransport
+				if !shared {
+					transport = newTransport()
+				}
+				clients[i] = &http.Client{Transport: transport}
+			}
+
+			var nextClient uint32
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Spread the goroutines across the logical clients
+				i := int(atomic.AddUint32(&nextClient, 1))
+				for pb.Next() {
+					i = (i + 1) % numberOfLogicalClients
+					resp, err := clients[i].Get(testUrls[i])
+					if err != nil {
+						b.Fatalf("client %d get failed: %s", i, err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("client %d expected status code %d but got %d", i, http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("client %d error while reading response body: %s", i, err)
+					}
+					if string(body) != testValues[i] {
+						b.Fatalf("client %d expected body %q but got %q", i, testValues[i], body)
+					}
+				}
+			})
+
+			reportConnections(b, dialer)
+			reportRetainedHeap(b, baseline, clients)
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			baseline := liveHeap()
+			dialer := NewCountingDialer(dialLogicalApis(responses))
+			newClient := func() *fasthttp.Client {
+				return &fasthttp.Client{
+					Dial: dialer.Dial,
+					// Set the maximum number of connections equal to the max number of processes
+					MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+			}
+
+			sharedClient := newClient()
+			clients := make([]*fasthttp.Client, numberOfLogicalClients)
+			for i := range clients {
+				clients[i] = sharedClient
+				if !shared {
+					clients[i] = newClient()
+				}
+			}
+
+			var nextClient uint32
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Spread the goroutines across the logical clients
+				i := int(atomic.AddUint32(&nextClient, 1))
+				var buffer []byte
+				for pb.Next() {
+					i = (i + 1) % numberOfLogicalClients
+					statusCode, body, err := clients[i].Get(buffer, testUrls[i])
+					if err != nil {
+						b.Fatalf("client %d get failed: %s", i, err)
+					}
+					if statusCode != fasthttp.StatusOK {
+						b.Fatalf("client %d expected status code %d but got %d", i, fasthttp.StatusOK, statusCode)
+					}
+					if string(body) != testValues[i] {
+						b.Fatalf("client %d expected body %q but got %q", i, testValues[i], body)
+					}
+					buffer = body
+				}
+			})
+
+			reportConnections(b, dialer)
+			reportRetainedHeap(b, baseline, clients)
+		})
+	}
+}
+
+const (
+	// Goroutines making slow requests, which hold on to their connection for a while
+	numberOfSlowGoroutines = 4
+	// Goroutines making fast requests, which return their connection right away
+	numberOfFastGoroutines = 12
+	// The latency of a slow request
+	slowRequestLatency = 2 * time.Millisecond
+	// Fewer connections than goroutines, so that they compete for the pool
+	churnMaxConns = 8
+)
+
+// ChurnStats counts the requests of a mixed workload and how long the fast ones took,
+// which grows as they wait for connections that slow requests are holding
+type ChurnStats struct {
+	fastRequests int64
+	fastTotal    int64
+	slowRequests int64
+}
+
+func (s *ChurnStats) Observe(slow bool, elapsed time.Duration) {
+	if slow {
+		atomic.AddInt64(&s.slowRequests, 1)
+		return
+	}
+	atomic.AddInt64(&s.fastRequests, 1)
+	atomic.AddInt64(&s.fastTotal, int64(elapsed))
+}
+
+func (s *ChurnStats) Report(b *testing.B, elapsed time.Duration, dialer *CountingDialer) {
+	fastRequests := atomic.LoadInt64(&s.fastRequests)
+	slowRequests := atomic.LoadInt64(&s.slowRequests)
+	if fastRequests != int64(b.N) {
+		b.Fatalf("expected %d fast requests to succeed but only %d did", b.N, fastRequests)
+	}
+	b.ReportMetric(float64(fastRequests+slowRequests)/elapsed.Seconds(), "req/s")
+	b.ReportMetric(float64(atomic.LoadInt64(&s.fastTotal))/float64(fastRequests), "fast-ns")
+	b.ReportMetric(float64(slowRequests), "slow-reqs")
+	b.ReportMetric(float64(dialer.Count()), "conns")
+}
+
+// runChurnWorkload makes b.N fast requests spread across several goroutines, while
+// other goroutines keep making slow requests through the same client until the fast
+// ones are done
+func runChurnWorkload(b *testing.B, stats *ChurnStats, doRequest func(slow bool) error) time.Duration {
+	remaining := int64(b.N)
+	done := make(chan struct{})
+	var slowWorkers, fastWorkers sync.WaitGroup
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < numberOfSlowGoroutines; i++ {
+		slowWorkers.Add(1)
+		go func() {
+			defer slowWorkers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := doRequest(true); err != nil {
+					b.Errorf("slow request failed: %s", err)
+					return
+				}
+				stats.Observe(true, 0)
+			}
+		}()
+	}
+	for i := 0; i < numberOfFastGoroutines; i++ {
+		fastWorkers.Add(1)
+		go func() {
+			defer fastWorkers.Done()
+			for atomic.AddInt64(&remaining, -1) >= 0 {
+				requestStart := time.Now()
+				if err := doRequest(false); err != nil {
+					b.Errorf("fast request failed: %s", err)
+					return
+				}
+				stats.Observe(false, time.Since(requestStart))
+			}
+		}()
+	}
+	fastWorkers.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	close(done)
+	slowWorkers.Wait()
+	return elapsed
+}
+
+// BenchmarkClientPoolChurnMixed shares one pool between requests of very different
+// durations. Running it with -race also checks the pool's get and put paths while
+// many goroutines use them at once.
+func BenchmarkClientPoolChurnMixed(b *testing.B) {
+	testValue := "123"
+	testUrl := "http://host.test/query"
+	slowLatency := slowRequestLatency.String()
+
+	b.Run("NetHttp", func(b *testing.B) {
+		dialer := NewCountingDialer(dialMockServerWithLatency)
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial:                dialer.DialNetwork,
+				MaxConnsPerHost:     churnMaxConns,
+				MaxIdleConnsPerHost: churnMaxConns,
+			},
+		}
+
+		stats := &ChurnStats{}
+		b.ReportAllocs()
+		elapsed := runChurnWorkload(b, stats, func(slow bool) error {
+			req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+			if err != nil {
+				return fmt.Errorf("cannot create request: %s", err)
+			}
+			if slow {
+				req.Header.Set(mockLatencyHeader, slowLatency)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return fmt.Errorf("client get failed: %s", err)
+			}
+			// Read the response body
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("error while reading response body: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if string(body) != testValue {
+				return fmt.Errorf("expected body %q but got %q", testValue, body)
+			}
+			return nil
+		})
+
+		stats.Report(b, elapsed, dialer)
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		dialer := NewCountingDialer(dialMockServerWithLatency)
+		client := &fasthttp.Client{
+			Dial:            dialer.Dial,
+			MaxConnsPerHost: churnMaxConns,
+			// Wait for a connection to be returned rather than failing with ErrNoFreeConns
+			MaxConnWaitTimeout: time.Second,
+		}
+
+		stats := &ChurnStats{}
+		b.ReportAllocs()
+		elapsed := runChurnWorkload(b, stats, func(slow bool) error {
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+			if slow {
+				req.Header.Set(mockLatencyHeader, slowLatency)
+			}
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			if err := client.Do(req, resp); err != nil {
+				return fmt.Errorf("client get failed: %s", err)
+			}
+			if resp.StatusCode() != fasthttp.StatusOK {
+				return fmt.Errorf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			}
+			if string(resp.Body()) != testValue {
+				return fmt.Errorf("expected body %q but got %q", testValue, resp.Body())
+			}
+			return nil
+		})
+
+		stats.Report(b, elapsed, dialer)
+	})
+}
@@ -0,0 +1,203 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+)
+
+// The proxy listens next to the server it tunnels to
+const proxyHostAddress = "127.0.0.1:8543"
+
+// The credentials that the proxy expects, as "username:password"
+const proxyCredentials = "user:secret"
+
+// handleProxyConnect returns a handler for a proxy that tunnels CONNECT requests to
+// their target. If credentials are given, the request must present them in its
+// Proxy-Authorization header, or it's refused with a 407.
+func handleProxyConnect(credentials string) fasthttp.RequestHandler {
+	expectedAuthorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+
+	return func(ctx *fasthttp.RequestCtx) {
+		if !ctx.IsConnect() {
+			ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+			return
+		}
+		if credentials != "" && string(ctx.Request.Header.Peek("Proxy-Authorization")) != expectedAuthorization {
+			ctx.Response.Header.Set("Proxy-Authenticate", "Basic realm=\"proxy\"")
+			ctx.Error("Proxy Authentication Required", fasthttp.StatusProxyAuthRequired)
+			return
+		}
+
+		// The request URI of a CONNECT is the host and port to tunnel to
+		upstream, err := net.Dial("tcp4", string(ctx.RequestURI()))
+		if err != nil {
+			ctx.Error("Bad Gateway", fasthttp.StatusBadGateway)
+			return
+		}
+
+		// Once the 200 response has been sent, copy bytes both ways until either side
+		// closes the tunnel
+		ctx.Hijack(func(client net.Conn) {
+			go func() {
+				io.Copy(upstream, client)
+				upstream.Close()
+			}()
+			io.Copy(client, upstream)
+			upstream.Close()
+		})
+	}
+}
+
+func startProxyServer(tb testing.TB, credentials string) *TcpServer {
+	server := &fasthttp.Server{
+		Handler: handleProxyConnect(credentials),
+	}
+	return startServerOnListener(tb, listenTcpOn(tb, proxyHostAddress), server.Serve)
+}
+
+// expectProxyAuthRequired checks that a request failed because the proxy refused it
+func expectProxyAuthRequired(b *testing.B, err error) {
+	if err == nil {
+		b.Fatalf("expected the proxy to refuse a request without credentials")
+	}
+	// fasthttp reports the status code and net/http the status text
+	if !strings.Contains(err.Error(), "407") && !strings.Contains(err.Error(), fasthttp.StatusMessage(fasthttp.StatusProxyAuthRequired)) {
+		b.Fatalf("expected the proxy to respond with status code %d but got: %s", fasthttp.StatusProxyAuthRequired, err)
+	}
+}
+
+// BenchmarkClientProxyAuth tunnels HTTPS requests through a CONNECT proxy, with and
+// without proxy authentication. Every request opens a new tunnel, since that's when
+// the credentials are sent.
+func BenchmarkClientProxyAuth(b *testing.B) {
+	testValue := "123"
+
+	for _, authenticate := range []bool{false, true} {
+		name := "no-auth"
+		credentials := ""
+		if authenticate {
+			name = "basic-auth"
+			credentials = proxyCredentials
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Start a server and a proxy in front of it
+			server := startTlsServer(b)
+			defer server.Stop(b)
+			proxy := startProxyServer(b, credentials)
+			defer proxy.Stop(b)
+
+			newClient := func(proxyUrl *url.URL) *http.Client {
+				tlsConfig := newClientTlsConfig()
+				// Resume TLS sessions through new tunnels, as fasthttp does by default
+				tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+				return &http.Client{
+					Transport: &http.Transport{
+						Proxy:             http.ProxyURL(proxyUrl),
+						TLSClientConfig:   tlsConfig,
+						DisableKeepAlives: true,
+					},
+				}
+			}
+
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+			if authenticate {
+				// Without credentials in the proxy URL, the request is refused
+				_, err := newClient(&url.URL{Scheme: "http", Host: proxy.hostAddress}).Get(testUrl)
+				expectProxyAuthRequired(b, err)
+			}
+
+			// The credentials are the userinfo of the proxy URL
+			proxyUrl := &url.URL{Scheme: "http", Host: proxy.hostAddress}
+			if credentials != "" {
+				username, password, _ := strings.Cut(credentials, ":")
+				proxyUrl.User = url.UserPassword(username, password)
+			}
+			client := newClient(proxyUrl)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+				// Read the response body
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatalf("error while reading response body: %s", err)
+				}
+				if string(body) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, body)
+				}
+			}
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Start a server and a proxy in front of it
+			server := startTlsServer(b)
+			defer server.Stop(b)
+			proxy := startProxyServer(b, credentials)
+			defer proxy.Stop(b)
+
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+			if authenticate {
+				// Without credentials in front of the proxy address, the request is refused
+				client := &fasthttp.Client{
+					Dial:      fasthttpproxy.FasthttpHTTPDialer(proxy.hostAddress),
+					TLSConfig: newClientTlsConfig(),
+				}
+				_, _, err := client.Get(nil, testUrl)
+				expectProxyAuthRequired(b, err)
+			}
+
+			// The credentials go in front of the proxy address
+			proxyAddress := proxy.hostAddress
+			if credentials != "" {
+				proxyAddress = credentials + "@" + proxyAddress
+			}
+			client := &fasthttp.Client{
+				Dial:      fasthttpproxy.FasthttpHTTPDialer(proxyAddress),
+				TLSConfig: newClientTlsConfig(),
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+			// fasthttp has no option to disable keep-alive for a whole client
+			req.SetConnectionClose()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				if string(resp.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package fasthttp_request_perf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// handleEchoQueryRequest responds with every decoded query argument on a line of its
+// own, in the order they were sent
+func handleEchoQueryRequest(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.QueryArgs().VisitAll(func(key, value []byte) {
+		ctx.Write(key)
+		ctx.WriteString("=")
+		ctx.Write(value)
+		ctx.WriteString("\n")
+	})
+}
+
+// The query arguments that stay the same on every search
+const searchLimit, searchSort = 50, "created_at desc"
+
+// Searches cycled through so that consecutive requests have different query strings.
+// Most need escaping.
+var searchParameters = []struct {
+	q    string
+	page int
+}{
+	{"running shoes", 1},
+	{"café & bar", 2},
+	{"50% off", 3},
+	{"c++ books", 4},
+}
+
+// SearchRequest is a search with the URL it's sent to when built ahead of time, and
+// the body that handleEchoQueryRequest responds with
+type SearchRequest struct {
+	q         string
+	page      int
+	staticUrl string
+	expected  string
+}
+
+// makeSearchRequests builds every search in searchParameters against the base URL.
+// The arguments are in alphabetical order, since url.Values.Encode sorts them, and
+// fasthttp.Args keeps the order they were set in.
+func makeSearchRequests(baseUrl string) []SearchRequest {
+	var requests []SearchRequest
+	for _, p := range searchParameters {
+		values := url.Values{}
+		values.Set("limit", strconv.Itoa(searchLimit))
+		values.Set("page", strconv.Itoa(p.page))
+		values.Set("q", p.q)
+		values.Set("sort", searchSort)
+
+		expected := ""
+		for _, key := range []string{"limit", "page", "q", "sort"} {
+			expected += key + "=" + values.Get(key) + "\n"
+		}
+		requests = append(requests, SearchRequest{
+			q:         p.q,
+			page:      p.page,
+			staticUrl: baseUrl + "?" + values.Encode(),
+			expected:  expected,
+		})
+	}
+	return requests
+}
+
+// checkSearchResponse returns the error that the request failed with, or otherwise an
+// error if the body isn't the one expected for the search
+func checkSearchResponse(search SearchRequest, body []byte, err error) error {
+	if err == nil && string(body) != search.expected {
+		return fmt.Errorf("expected body %q but got %q", search.expected, body)
+	}
+	return err
+}
+
+// BenchmarkClientQueryArgsConstruction sets four query arguments, most of which need
+// escaping, on every request. The static benchmarks send URLs that were encoded ahead
+// of time, so the difference in allocs/op is the cost of encoding the query string:
+// url.Values is a map of slices that has to be filled in and sorted before Encode
+// builds a new string, while fasthttp.Args encodes into the request's own buffers.
+func BenchmarkClientQueryArgsConstruction(b *testing.B) {
+	for _, encode := range []bool{false, true} {
+		netHttpName, fastHttpName := "static", "static"
+		if encode {
+			netHttpName, fastHttpName = "url-values", "query-args"
+		}
+
+		b.Run("NetHttp/"+netHttpName, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleEchoQueryRequest)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			baseUrl := "http://" + server.hostAddress + "/search"
+			searches := makeSearchRequests(baseUrl)
+			b.ReportAllocs()
+			runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+				i := 0
+				return func(buffer []byte) (int, []byte, error) {
+					search := searches[i%len(searches)]
+					i++
+
+					testUrl := search.staticUrl
+					if encode {
+						values := url.Values{}
+						values.Set("limit", strconv.Itoa(searchLimit))
+						values.Set("page", strconv.Itoa(search.page))
+						values.Set("q", search.q)
+						values.Set("sort", searchSort)
+						testUrl = baseUrl + "?" + values.Encode()
+					}
+
+					statusCode, body, err := netHttpResult(client.Get(testUrl))
+					return statusCode, body, checkSearchResponse(search, body, err)
+				}
+			}, nil)
+		})
+
+		b.Run("FastHttp/"+fastHttpName, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleEchoQueryRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			baseUrl := "http://" + server.hostAddress + "/search"
+			searches := makeSearchRequests(baseUrl)
+			b.ReportAllocs()
+			runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+				// Create a request and a response once, and reuse them for every request
+				req := &fasthttp.Request{}
+				if encode {
+					req.SetRequestURI(baseUrl)
+				}
+				resp := &fasthttp.Response{}
+
+				i := 0
+				return func(buffer []byte) (int, []byte, error) {
+					search := searches[i%len(searches)]
+					i++
+
+					if encode {
+						// The arguments are reused, and encoded into the request URI when the
+						// request is written
+						args := req.URI().QueryArgs()
+						args.Reset()
+						args.SetUint("limit", searchLimit)
+						args.SetUint("page", search.page)
+						args.Set("q", search.q)
+						args.Set("sort", searchSort)
+					} else {
+						req.SetRequestURI(search.staticUrl)
+					}
+
+					err := client.Do(req, resp)
+					return resp.StatusCode(), resp.Body(), checkSearchResponse(search, resp.Body(), err)
+				}
+			}, nil)
+		})
+	}
+}
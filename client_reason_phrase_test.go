@@ -0,0 +1,116 @@
+package fasthttp_request_perf
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Status lines with and without the reason phrase. RFC 9112 makes the reason phrase
+// optional but keeps the space in front of it, and clients should accept a status
+// line without either.
+var reasonPhraseModes = []struct {
+	name       string
+	statusLine string
+}{
+	{"with-reason", "HTTP/1.1 200 OK"},
+	{"missing-reason", "HTTP/1.1 200"},
+	{"empty-reason", "HTTP/1.1 200 "},
+}
+
+// makeMockResponseWithStatusLine builds the mock response with another status line
+func makeMockResponseWithStatusLine(statusLine string) []byte {
+	return []byte(statusLine + "\r\nContent-Type: test/plain\r\nContent-Length: 3\r\n\r\n123")
+}
+
+// newNetHttpClientWithResponse returns an http.Client whose connections answer every
+// request with the given response
+func newNetHttpClientWithResponse(response []byte) *http.Client {
+	dial := dialMockServerWithResponse(response)
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return dial(addr)
+			},
+			// Set the maximum number of idle connections equal to the max number of processes
+			MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+		},
+	}
+}
+
+func TestClientParsesMissingReasonPhrase(t *testing.T) {
+	testValue := "123"
+	testUrl := "http://host.test/query"
+
+	for _, mode := range reasonPhraseModes {
+		response := makeMockResponseWithStatusLine(mode.statusLine)
+
+		t.Run("NetHttp/"+mode.name, func(t *testing.T) {
+			client := newNetHttpClientWithResponse(response)
+			statusCode, body, err := netHttpResult(client.Get(testUrl))
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != http.StatusOK {
+				t.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		})
+
+		t.Run("FastHttp/"+mode.name, func(t *testing.T) {
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithResponse(response),
+			}
+			statusCode, body, err := client.Get(nil, testUrl)
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		})
+	}
+}
+
+// BenchmarkClientMissingReasonPhrase compares parsing the status line with and
+// without a reason phrase, which should cost the same
+func BenchmarkClientMissingReasonPhrase(b *testing.B) {
+	testValue := []byte("123")
+	testUrl := "http://host.test/query"
+
+	for _, mode := range reasonPhraseModes {
+		response := makeMockResponseWithStatusLine(mode.statusLine)
+
+		b.Run("NetHttp/"+mode.name, func(b *testing.B) {
+			client := newNetHttpClientWithResponse(response)
+
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			}, testValue)
+		})
+
+		b.Run("FastHttp/"+mode.name, func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithResponse(response),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			b.ReportAllocs()
+			runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
+			}, testValue)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package fasthttp_request_perf
+
+import (
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func handleEchoPathRequest(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(ctx.Path())
+}
+
+// UriTemplate is a route template such as "/users/{id}" that has been split into its
+// literal parts and placeholders ahead of time, so that filling it in only appends
+type UriTemplate struct {
+	// literals[i] comes before placeholders[i], and the final literal comes last
+	literals     []string
+	placeholders []string
+}
+
+func CompileUriTemplate(template string) *UriTemplate {
+	t := &UriTemplate{}
+	for {
+		start := strings.IndexByte(template, '{')
+		end := strings.IndexByte(template, '}')
+		if start < 0 || end < start {
+			break
+		}
+		t.literals = append(t.literals, template[:start])
+		t.placeholders = append(t.placeholders, template[start+1:end])
+		template = template[end+1:]
+	}
+	t.literals = append(t.literals, template)
+	return t
+}
+
+// Append fills in the placeholders with values, given in the order they appear in
+// the template
+func (t *UriTemplate) Append(dst []byte, values ...string) []byte {
+	for i, value := range values {
+		dst = append(dst, t.literals[i]...)
+		dst = append(dst, value...)
+	}
+	return append(dst, t.literals[len(t.literals)-1]...)
+}
+
+// Placeholder values cycled through so that consecutive requests have different URIs,
+// along with the path each should produce
+var templateParameters = []struct {
+	id, orderId, path string
+}{
+	{"1", "7", "/users/1/orders/7"},
+	{"42", "365", "/users/42/orders/365"},
+	{"1337", "8080", "/users/1337/orders/8080"},
+	{"90210", "31415", "/users/90210/orders/31415"},
+}
+
+func BenchmarkClientTemplatedRequestConstruction(b *testing.B) {
+	routeTemplate := "/users/{id}/orders/{orderId}"
+	compiledTemplate := CompileUriTemplate(routeTemplate)
+
+	// Each strategy builds the full URI for the given base URL and placeholder values
+	strategies := []struct {
+		name  string
+		build func(dst []byte, baseUrl, id, orderId string) []byte
+	}{
+		{"direct", func(dst []byte, baseUrl, id, orderId string) []byte {
+			return append(dst, baseUrl+"/users/"+id+"/orders/"+orderId...)
+		}},
+		{"naive-replace", func(dst []byte, baseUrl, id, orderId string) []byte {
+			path := strings.Replace(routeTemplate, "{id}", id, 1)
+			path = strings.Replace(path, "{orderId}", orderId, 1)
+			return append(dst, baseUrl+path...)
+		}},
+		{"precompiled", func(dst []byte, baseUrl, id, orderId string) []byte {
+			dst = append(dst, baseUrl...)
+			return compiledTemplate.Append(dst, id, orderId)
+		}},
+	}
+
+	for _, strategy := range strategies {
+		build := strategy.build
+
+		b.Run("NetHttp/"+strategy.name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleEchoPathRequest)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			baseUrl := "http://" + server.hostAddress
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				var uri []byte
+				i := 0
+				for pb.Next() {
+					params := templateParameters[i%len(templateParameters)]
+					i++
+
+					// net/http needs the URL as a string
+					uri = build(uri[:0], baseUrl, params.id, params.orderId)
+					resp, err := client.Get(string(uri))
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if string(body) != params.path {
+						b.Fatalf("expected body %q but got %q", params.path, body)
+					}
+				}
+			})
+		})
+
+		b.Run("FastHttp/"+strategy.name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleEchoPathRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			baseUrl := "http://" + server.hostAddress
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				var uri []byte
+				i := 0
+				for pb.Next() {
+					params := templateParameters[i%len(templateParameters)]
+					i++
+
+					// fasthttp accepts the URI as bytes, so the buffer can be reused
+					uri = build(uri[:0], baseUrl, params.id, params.orderId)
+					req.SetRequestURIBytes(uri)
+
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != params.path {
+						b.Fatalf("expected body %q but got %q", params.path, resp.Body())
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The response header in which the server assigns each request its ID
+const requestIdHeader = "X-Request-Id"
+
+// The prefix of every request ID, followed by a number unique to the response
+const requestIdPrefix = "req-"
+
+// handleRequestWithRequestId returns a handler that responds like handleRequest, with
+// a unique ID for every response
+func handleRequestWithRequestId() fasthttp.RequestHandler {
+	var nextId uint64
+	return func(ctx *fasthttp.RequestCtx) {
+		var buffer [32]byte
+		id := strconv.AppendUint(append(buffer[:0], requestIdPrefix...), atomic.AddUint64(&nextId, 1), 10)
+		ctx.Response.Header.SetBytesV(requestIdHeader, id)
+		handleRequest(ctx)
+	}
+}
+
+// checkRequestId fails the benchmark unless the ID came from the server and differs
+// from the previous one
+func checkRequestId(b *testing.B, id, previous string) {
+	if len(id) <= len(requestIdPrefix) || !strings.HasPrefix(id, requestIdPrefix) {
+		b.Fatalf("expected a request ID starting with %q but got %q", requestIdPrefix, id)
+	}
+	if id == previous {
+		b.Fatalf("expected a unique request ID but got %q twice", id)
+	}
+}
+
+// checkRequestIdBytes is checkRequestId for fasthttp, without converting the IDs to
+// strings on every request
+func checkRequestIdBytes(b *testing.B, id, previous []byte) {
+	if len(id) <= len(requestIdPrefix) || !bytes.HasPrefix(id, []byte(requestIdPrefix)) {
+		b.Fatalf("expected a request ID starting with %q but got %q", requestIdPrefix, id)
+	}
+	if bytes.Equal(id, previous) {
+		b.Fatalf("expected a unique request ID but got %q twice", id)
+	}
+}
+
+// reportRequestIdExtraction times extracting the ID from a response on its own, and
+// reports it as extract-ns and as extract-% of the time each request took
+func reportRequestIdExtraction(b *testing.B, extract func()) {
+	b.StopTimer()
+	requestNs := float64(b.Elapsed().Nanoseconds()) / float64(b.N)
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		extract()
+	}
+	extractNs := float64(time.Since(start).Nanoseconds()) / float64(b.N)
+
+	b.ReportMetric(extractNs, "extract-ns")
+	b.ReportMetric(100*extractNs/requestNs, "extract-%")
+}
+
+// BenchmarkClientExtractRequestID reads the request ID that the server assigned from
+// every response and keeps it, as a client would to correlate its logs with the
+// server's. net/http has already parsed every header into a map, so the lookup only
+// canonicalizes the name and hashes it, while fasthttp's Peek scans the headers it
+// parsed in place and the ID has to be copied out before the response is reused.
+func BenchmarkClientExtractRequestID(b *testing.B) {
+	testValue := "123"
+
+	b.Run("NetHttp/map-lookup", func(b *testing.B) {
+		// Start a server
+		server := startTcpServerWithHandler(b, handleRequestWithRequestId())
+		defer server.Stop(b)
+
+		// Create an http.Client
+		client := &http.Client{
+			// Set the maximum number of idle connections equal to the current max number of processes
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+			},
+		}
+
+		testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+		b.ReportAllocs()
+		runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+			var id, previous string
+			return func(buffer []byte) (int, []byte, error) {
+				resp, err := client.Get(testUrl)
+				statusCode, body, err := netHttpResult(resp, err)
+				if err == nil && statusCode == http.StatusOK {
+					// The value is a string that the response no longer needs, so storing
+					// it doesn't copy
+					previous, id = id, resp.Header.Get(requestIdHeader)
+					checkRequestId(b, id, previous)
+				}
+				return statusCode, body, err
+			}
+		}, []byte(testValue))
+
+		resp, err := client.Get(testUrl)
+		if _, _, err := netHttpResult(resp, err); err != nil {
+			b.Fatalf("client get failed: %s", err)
+		}
+		var id string
+		reportRequestIdExtraction(b, func() {
+			id = resp.Header.Get(requestIdHeader)
+		})
+		checkRequestId(b, id, "")
+	})
+
+	b.Run("FastHttp/peek", func(b *testing.B) {
+		// Start a server
+		server := startTcpServerWithHandler(b, handleRequestWithRequestId())
+		defer server.Stop(b)
+
+		// Create a fasthttp.Client
+		client := &fasthttp.Client{
+			// Set the maximum number of connections equal to the max number of processes
+			MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+		}
+
+		testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+		b.ReportAllocs()
+		runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+			// Create a request and a response once, and reuse them for every request
+			req := &fasthttp.Request{}
+			req.SetRequestURI(testUrl)
+			resp := &fasthttp.Response{}
+
+			var id, previous []byte
+			return func(buffer []byte) (int, []byte, error) {
+				err := client.Do(req, resp)
+				if err == nil && resp.StatusCode() == fasthttp.StatusOK {
+					// The value points into the response, which the next request overwrites,
+					// so copy it into a buffer of our own
+					previous, id = id, append(previous[:0], resp.Header.Peek(requestIdHeader)...)
+					checkRequestIdBytes(b, id, previous)
+				}
+				return resp.StatusCode(), resp.Body(), err
+			}
+		}, []byte(testValue))
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(testUrl)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		if err := client.Do(req, resp); err != nil {
+			b.Fatalf("client get failed: %s", err)
+		}
+		var id []byte
+		reportRequestIdExtraction(b, func() {
+			id = append(id[:0], resp.Header.Peek(requestIdHeader)...)
+		})
+		checkRequestIdBytes(b, id, nil)
+	})
+}
@@ -0,0 +1,147 @@
+package fasthttp_request_perf
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The most times a request is attempted before giving up
+const retryAttempts = 3
+
+// dialMockServerFailingFirstAttempt returns a dial function that alternates between a
+// connection that breaks with a short write and a healthy one, which closes once it
+// has responded. Neither can be reused, so as long as the requests are made one at a
+// time, the first attempt of every request fails and the retry succeeds. A client
+// that returned the broken connection to its pool would retry on it and fail again.
+func dialMockServerFailingFirstAttempt(closes *int64) fasthttp.DialFunc {
+	faulty := dialMockServerWithFaultCountingCloses(mockFaultShortWrite, closes)
+	healthy := dialMockServerWithResponse(mockClosingResponseData)
+	var dials int64
+	return func(addr string) (net.Conn, error) {
+		if atomic.AddInt64(&dials, 1)%2 == 1 {
+			return faulty(addr)
+		}
+		conn, err := healthy(addr)
+		if err != nil {
+			return nil, err
+		}
+		return CloseCountingConn{MockConn: conn.(*MockConn), closes: closes}, nil
+	}
+}
+
+// retryRequest makes the request until it succeeds, up to retryAttempts times, and
+// returns how many attempts failed along the way
+func retryRequest(doRequest func() (statusCode int, body []byte, err error)) (statusCode int, body []byte, failures int, err error) {
+	for attempt := 1; ; attempt++ {
+		statusCode, body, err = doRequest()
+		if err == nil || attempt == retryAttempts {
+			return statusCode, body, failures, err
+		}
+		failures++
+	}
+}
+
+// runRetryBenchmark makes b.N requests one after another, so that each gets the
+// faulty connection first, and checks that each one eventually got the body
+func runRetryBenchmark(b *testing.B, dialer *CountingDialer, closes *int64, doRequest func() (statusCode int, body []byte, failures int, err error)) {
+	testValue := "123"
+	var failures int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		statusCode, body, failed, err := doRequest()
+		if err != nil {
+			b.Fatalf("client get failed after retrying: %s", err)
+		}
+		if statusCode != http.StatusOK {
+			b.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+		}
+		if string(body) != testValue {
+			b.Fatalf("expected body %q but got %q", testValue, body)
+		}
+		failures += failed
+	}
+	b.StopTimer()
+
+	// Every request dials the faulty connection and then the healthy one
+	if dialer.Count() != int64(2*b.N) {
+		b.Fatalf("expected %d connections for %d requests but got %d", 2*b.N, b.N, dialer.Count())
+	}
+	expectClosedConnections(b, dialer, closes)
+	b.ReportMetric(float64(failures)/float64(b.N), "failed-attempts/op")
+	b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+}
+
+// BenchmarkClientRetryOnConnectionError retries requests whose first attempt fails
+// with a connection error. net/http only retries by itself on a connection that was
+// reused from its pool, so it needs a retry loop for a new connection that breaks.
+// fasthttp retries idempotent requests by itself, which the manual loop turns off to
+// compare against. Its built-in retries don't surface the failed attempts, so for
+// those, failed-attempts/op is 0 and dials/op shows them instead.
+func BenchmarkClientRetryOnConnectionError(b *testing.B) {
+	testUrl := "http://host.test/query"
+
+	b.Run("NetHttp/manual-retry", func(b *testing.B) {
+		var closes int64
+		dialer := NewCountingDialer(dialMockServerFailingFirstAttempt(&closes))
+		// Create an http.Client
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: dialer.DialNetwork,
+			},
+		}
+
+		runRetryBenchmark(b, dialer, &closes, func() (int, []byte, int, error) {
+			return retryRequest(func() (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			})
+		})
+	})
+
+	b.Run("FastHttp/manual-retry", func(b *testing.B) {
+		var closes int64
+		dialer := NewCountingDialer(dialMockServerFailingFirstAttempt(&closes))
+		// Create a client
+		client := &fasthttp.Client{
+			Dial: dialer.Dial,
+			// Leave the retrying to the loop
+			MaxIdemponentCallAttempts: 1,
+		}
+
+		var buffer []byte
+		runRetryBenchmark(b, dialer, &closes, func() (int, []byte, int, error) {
+			return retryRequest(func() (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				statusCode, body, err := client.Get(buffer[:0], testUrl)
+				buffer = body
+				return statusCode, body, err
+			})
+		})
+	})
+
+	b.Run("FastHttp/built-in-retry", func(b *testing.B) {
+		var closes int64
+		dialer := NewCountingDialer(dialMockServerFailingFirstAttempt(&closes))
+		// Create a client
+		client := &fasthttp.Client{
+			Dial:                      dialer.Dial,
+			MaxIdemponentCallAttempts: retryAttempts,
+			// Retry a GET after any error, as the manual loop does
+			RetryIf: func(req *fasthttp.Request) bool {
+				return req.Header.IsGet()
+			},
+		}
+
+		var buffer []byte
+		runRetryBenchmark(b, dialer, &closes, func() (int, []byte, int, error) {
+			// Append the body to the one from the previous request to reuse its memory
+			statusCode, body, err := client.Get(buffer[:0], testUrl)
+			buffer = body
+			return statusCode, body, 0, err
+		})
+	})
+}
@@ -0,0 +1,192 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// MockConnPool hands out connections to a MockConn, with their buffers, to one request
+// at a time. It's the least state that a client needs to keep between requests, and
+// none of the bookkeeping of a real connection pool. The idle connections are kept in
+// a slice rather than a sync.Pool, which would drop them on every garbage collection
+// without closing them, and make the benchmark dial again.
+type MockConnPool struct {
+	dial fasthttp.DialFunc
+	mu   sync.Mutex
+	idle []*PooledConn
+}
+
+func NewMockConnPool(dial fasthttp.DialFunc) *MockConnPool {
+	return &MockConnPool{dial: dial}
+}
+
+func (p *MockConnPool) Get() (*PooledConn, error) {
+	p.mu.Lock()
+	if len(p.idle) > 0 {
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial("host.test")
+	if err != nil {
+		return nil, err
+	}
+	return &PooledConn{Conn: c, reader: bufio.NewReader(c), writer: bufio.NewWriter(c)}, nil
+}
+
+func (p *MockConnPool) Put(conn *PooledConn) {
+	p.mu.Lock()
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// CloseIdleConnections closes every connection that's waiting to be reused
+func (p *MockConnPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.idle {
+		conn.Close()
+	}
+	p.idle = nil
+}
+
+// MockRoundTripper is an http.RoundTripper that writes each request with Request.Write
+// and reads the response with http.ReadResponse, on a connection from a MockConnPool.
+// Unlike http.Transport, it has no idle connection lists, no goroutines reading and
+// writing each connection, and no handing of requests over channels, so what's left
+// is net/http encoding the request and decoding the response.
+type MockRoundTripper struct {
+	pool *MockConnPool
+}
+
+func (t *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := t.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	err = req.Write(conn.writer)
+	if err == nil {
+		err = conn.writer.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(conn.reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &mockRoundTripperBody{ReadCloser: resp.Body, pool: t.pool, conn: conn}
+	return resp, nil
+}
+
+// mockRoundTripperBody returns the connection to the pool once the body is closed,
+// since the body is read from the connection until then
+type mockRoundTripperBody struct {
+	io.ReadCloser
+	pool *MockConnPool
+	conn *PooledConn
+}
+
+func (b *mockRoundTripperBody) Close() error {
+	// Closing the body reads whatever is left of it, so the next response starts at
+	// the beginning of the buffer
+	err := b.ReadCloser.Close()
+	if err != nil {
+		b.conn.Close()
+		return err
+	}
+	b.pool.Put(b.conn)
+	return nil
+}
+
+// BenchmarkNetHttpRoundTripperToMockServer is BenchmarkNetHttpClientToMockServer with
+// a MockRoundTripper in place of the http.Transport, so the difference between the two
+// is the cost of the transport's connection pooling
+func BenchmarkNetHttpRoundTripperToMockServer(b *testing.B) {
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makePayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			// Create an http.Client
+			pool := NewMockConnPool(dialMockServerWithBodySize(bodySize))
+			defer pool.CloseIdleConnections()
+			client := &http.Client{
+				Transport: &MockRoundTripper{
+					pool: pool,
+				},
+			}
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+// BenchmarkFastHttpWriteReadToMockServer is the fasthttp counterpart of
+// BenchmarkNetHttpRoundTripperToMockServer. It writes each request with Request.Write
+// and reads the response with Response.Read on a connection from a MockConnPool, in
+// place of the fasthttp.Client.
+func BenchmarkFastHttpWriteReadToMockServer(b *testing.B) {
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makePayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			pool := NewMockConnPool(dialMockServerWithBodySize(bodySize))
+			defer pool.CloseIdleConnections()
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				conn, err := pool.Get()
+				if err != nil {
+					return 0, nil, err
+				}
+
+				// Acquire a request instance
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+
+				// Acquire a response instance
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				err = req.Write(conn.writer)
+				if err == nil {
+					err = conn.writer.Flush()
+				}
+				if err == nil {
+					err = resp.Read(conn.reader)
+				}
+				if err != nil {
+					conn.Close()
+					return 0, nil, err
+				}
+				pool.Put(conn)
+				// The body belongs to the response, which is about to be released, so copy
+				// it into the buffer that the benchmark hands back each time
+				return resp.StatusCode(), append(buffer[:0], resp.Body()...), nil
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
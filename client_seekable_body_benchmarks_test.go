@@ -0,0 +1,206 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Uploads from a small document up to a file that's larger than fasthttp's default
+// MaxRequestBodySize
+var seekableUploadSizes = []int{64 << 10, 4 << 20}
+
+// The largest body that the server accepts, which leaves room for every upload size
+const maxSeekableUploadSize = 8 << 20
+
+// handleSeekableUploadRequest responds like handleUploadRequest, after saying how the
+// body was framed: either with the Content-Length that the server received, or as
+// chunked
+func handleSeekableUploadRequest(ctx *fasthttp.RequestCtx) {
+	framing := "chunked"
+	if contentLength := ctx.Request.Header.ContentLength(); contentLength >= 0 {
+		framing = "content-length=" + strconv.Itoa(contentLength)
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.WriteString(framing + " " + uploadSummary(ctx.PostBody()))
+}
+
+// FileBody is an io.ReadSeeker that neither client recognizes, as an *os.File isn't.
+// Wrapping a bytes.Reader in it stops net/http from taking the length from the reader
+// itself.
+type FileBody struct {
+	io.ReadSeeker
+}
+
+// seekContentLength finds how much of the body is left to read by seeking to its end,
+// and then seeks back to where it was
+func seekContentLength(body io.ReadSeeker) (int64, error) {
+	current, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := body.Seek(current, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - current, nil
+}
+
+// ByteCountingConn counts the bytes written to a connection, which includes the
+// request's headers and the framing of its body
+type ByteCountingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c ByteCountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// dialTcpCountingBytes dials over TCP, and adds the bytes written to each connection
+// to the counter
+func dialTcpCountingBytes(written *int64) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := fasthttp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return ByteCountingConn{Conn: conn, written: written}, nil
+	}
+}
+
+// reportUploadFraming reports the bytes written for each request beyond the body
+// itself as overhead-B/op, which covers the headers and, for a chunked body, the
+// size line and CRLF around each chunk
+func reportUploadFraming(b *testing.B, written *int64, bodySize int) {
+	b.StopTimer()
+	overhead := atomic.LoadInt64(written) - int64(b.N)*int64(bodySize)
+	b.ReportMetric(float64(overhead)/float64(b.N), "overhead-B/op")
+}
+
+// BenchmarkClientSeekableBodyContentLength uploads a body from an io.ReadSeeker, as a
+// client uploading a file would. The Content-Length either comes from seeking to the
+// end of the body, or isn't sent, so the body is chunked. Chunking costs a few bytes
+// for every chunk, which is 4KB for fasthttp and 32KB for net/http, as overhead-B/op
+// shows for the larger body. A server that gets a Content-Length can also reject a
+// body that's too large before reading any of it, and allocate a buffer of the right
+// size up front.
+func BenchmarkClientSeekableBodyContentLength(b *testing.B) {
+	for _, bodySize := range seekableUploadSizes {
+		payload := makePayload(bodySize)
+		sizeName := fmt.Sprintf("size=%dKB", bodySize>>10)
+
+		for _, seek := range []bool{true, false} {
+			name := "chunked"
+			framing := "chunked"
+			if seek {
+				name = "content-length"
+				framing = "content-length=" + strconv.Itoa(bodySize)
+			}
+			testValue := []byte(framing + " " + uploadSummary(payload))
+
+			b.Run("NetHttp/"+name+"/"+sizeName, func(b *testing.B) {
+				// Start a server
+				server := startTcpServerWithServer(b, &fasthttp.Server{
+					Handler:            handleSeekableUploadRequest,
+					MaxRequestBodySize: maxSeekableUploadSize,
+				})
+				defer server.Stop(b)
+
+				// Create an http.Client
+				var written int64
+				dial := dialTcpCountingBytes(&written)
+				client := &http.Client{
+					Transport: &http.Transport{
+						Dial: func(network, addr string) (net.Conn, error) {
+							return dial(addr)
+						},
+						// Set the maximum number of idle connections equal to the current max number of processes
+						MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+					},
+				}
+
+				testUrl := "http://" + server.hostAddress + "/upload"
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					body := FileBody{bytes.NewReader(payload)}
+					req, err := http.NewRequest(http.MethodPost, testUrl, body)
+					if err != nil {
+						return 0, nil, err
+					}
+					// net/http chunks a body whose ContentLength is left at 0
+					if seek {
+						req.ContentLength, err = seekContentLength(body)
+						if err != nil {
+							return 0, nil, err
+						}
+					}
+					return netHttpResult(client.Do(req))
+				}, testValue)
+				reportUploadFraming(b, &written, bodySize)
+			})
+
+			b.Run("FastHttp/"+name+"/"+sizeName, func(b *testing.B) {
+				// Start a server
+				server := startTcpServerWithServer(b, &fasthttp.Server{
+					Handler:            handleSeekableUploadRequest,
+					MaxRequestBodySize: maxSeekableUploadSize,
+				})
+				defer server.Stop(b)
+
+				// Create a fasthttp.Client
+				var written int64
+				client := &fasthttp.Client{
+					Dial: dialTcpCountingBytes(&written),
+					// Set the maximum number of connections equal to the max number of processes
+					MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+				}
+
+				testUrl := "http://" + server.hostAddress + "/upload"
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				runClientBenchmark(b, func(buffer []byte) (int, []byte, error) {
+					req := fasthttp.AcquireRequest()
+					defer fasthttp.ReleaseRequest(req)
+					req.SetRequestURI(testUrl)
+					req.Header.SetMethod(fasthttp.MethodPost)
+
+					body := FileBody{bytes.NewReader(payload)}
+					// A body stream of size -1 is chunked
+					size := -1
+					if seek {
+						contentLength, err := seekContentLength(body)
+						if err != nil {
+							return 0, nil, err
+						}
+						size = int(contentLength)
+					}
+					req.SetBodyStream(body, size)
+
+					resp := fasthttp.AcquireResponse()
+					defer fasthttp.ReleaseResponse(resp)
+
+					err := client.Do(req, resp)
+					// The body belongs to the response, which is about to be released, so copy
+					// it into the buffer that the benchmark hands back each time
+					return resp.StatusCode(), append(buffer[:0], resp.Body()...), err
+				}, testValue)
+				reportUploadFraming(b, &written, bodySize)
+			})
+		}
+	}
+}
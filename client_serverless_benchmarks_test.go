@@ -2,12 +2,17 @@ package fasthttp_request_perf
 
 import (
 	"bytes"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"os"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -15,14 +20,92 @@ import (
 type MockConn struct {
 	net.Conn
 	numberOfBytesRead int
-	hasBeenRequested  chan struct{}
+	// Each complete request sends the latency of its response, so that a pipelining
+	// client can have up to mockMaxPendingRequests requests in flight before its
+	// writes block, and each response is served in the order it was requested
+	hasBeenRequested chan time.Duration
+
+	// The response is only sent once the whole request, including as much of a body as
+	// its Content-Length announces, has been written. Until the end of the headers is
+	// found, the written bytes are kept in requestHeaders; after that, only the length
+	// of the body that remains is tracked. Anything written after the end of the body
+	// is the start of the next request. A client sending "Expect: 100-continue" never
+	// receives the interim response it's waiting for, so it has to time out before
+	// sending the body.
+	requestHeaders       []byte
+	isReadingRequestBody bool
+	remainingRequestBody int
+
+	// If simulateLatency is set, each response is delayed by the duration that the
+	// request asks for in its X-Mock-Latency header, as a slow upstream would be. The
+	// latency of the request whose body is being written is kept until it's complete.
+	simulateLatency bool
+	requestLatency  time.Duration
+	// The last X-Mock-Latency value that was parsed, so that a client sending the same
+	// one on every request doesn't make the MockConn allocate to parse it again
+	parsedLatencyValue []byte
+	parsedLatency      time.Duration
+
+	// The response to send instead of mockResponseData, if set
+	response []byte
+	// If closeAfterResponse is set, the server closes the connection once it has sent
+	// the response, so every read after that returns io.EOF
+	closeAfterResponse bool
+	// If maxResponses is set, the server closes the connection once it has sent that
+	// many responses, as one with a limit on keep-alive requests would. The client
+	// only finds out when it next reads from the connection.
+	maxResponses int
+	responses    int
+
+	// The network fault to simulate, if any
+	fault        MockFault
+	readDeadline time.Time
+
+	// A connection with a closed channel, such as a faulty one, is never returned to
+	// mockServerConnectionPool; instead, closing it unblocks any Read still waiting on
+	// it, as a client may close a connection while another goroutine reads from it
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// MockFault is a network fault that a MockConn can simulate
+type MockFault int
+
+const (
+	mockFaultNone MockFault = iota
+	// The connection breaks partway through the response
+	mockFaultUnexpectedEOF
+	// The response never arrives, so the read blocks until its deadline passes or the
+	// client gives up and closes the connection
+	mockFaultTimeout
+	// Only part of the request is written before the write fails
+	mockFaultShortWrite
+)
+
+func (f MockFault) String() string {
+	switch f {
+	case mockFaultUnexpectedEOF:
+		return "unexpected-eof"
+	case mockFaultTimeout:
+		return "timeout"
+	case mockFaultShortWrite:
+		return "short-write"
+	}
+	return "none"
 }
 
+// The request header that tells a MockConn how long to delay the response
+const mockLatencyHeader = "X-Mock-Latency"
+
+// The number of requests that can be written to a MockConn before their responses
+// are read
+const mockMaxPendingRequests = 128
+
 var mockResponseData = []byte("HTTP/1.1 200 OK\r\nContent-Type: test/plain\r\nContent-Length: 3\r\n\r\n123")
 var mockServerConnectionPool = sync.Pool{
 	New: func() interface{} {
 		return &MockConn{
-			hasBeenRequested: make(chan struct{}, 1),
+			hasBeenRequested: make(chan time.Duration, mockMaxPendingRequests),
 		}
 	},
 }
@@ -31,33 +114,229 @@ func (c *MockConn) Read(b []byte) (int, error) {
 	// If no bytes have been read yet, we know that the request has not been made yet
 	// So, we'll wait for a request to come through
 	if c.numberOfBytesRead == 0 {
-		<-c.hasBeenRequested
+		latency, err := c.waitForRequest()
+		if err != nil {
+			return 0, err
+		}
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+	}
+	if c.fault == mockFaultTimeout {
+		return 0, c.waitForReadDeadline()
 	}
 
-	// While there is still buffer left, copy over the response bytes
-	n := 0
-	for len(b) > 0 {
-		if c.numberOfBytesRead == len(mockResponseData) {
-			// Reset the number of bytes read for this connection
-			c.numberOfBytesRead = 0
-			return n, nil
+	response := mockResponseData
+	if c.response != nil {
+		response = c.response
+	}
+	if c.fault == mockFaultUnexpectedEOF {
+		// Cut the response off halfway
+		response = response[:len(response)/2]
+		if c.numberOfBytesRead == len(response) {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	if c.closeAfterResponse && c.numberOfBytesRead == len(response) {
+		// The server has closed the connection, which marks the end of the body
+		return 0, io.EOF
+	}
+
+	// Copy as much of the rest of the response as fits, however small the buffer and
+	// however large the response
+	n := copy(b, response[c.numberOfBytesRead:])
+	c.numberOfBytesRead += n
+	if c.numberOfBytesRead == len(response) {
+		c.responses++
+		if c.maxResponses > 0 && c.responses >= c.maxResponses {
+			c.closeAfterResponse = true
 		}
-		// Otherwise, copy over more bytes
-		n = copy(b, mockResponseData[c.numberOfBytesRead:])
-		c.numberOfBytesRead += n
-		b = b[n:]
+	}
+	if c.numberOfBytesRead == len(response) && c.fault != mockFaultUnexpectedEOF && !c.closeAfterResponse {
+		// The whole response has been read, so the next read waits for another request,
+		// unless one is already pending
+		c.numberOfBytesRead = 0
 	}
 	return n, nil
 }
 
+// waitForRequest blocks until a request has been written and returns the latency of
+// its response. Like a socket, it gives up once the read deadline passes. Most reads
+// come after the request has been written, so a timer is only started when there is
+// nothing to respond to yet.
+func (c *MockConn) waitForRequest() (time.Duration, error) {
+	select {
+	case latency := <-c.hasBeenRequested:
+		return latency, nil
+	default:
+	}
+
+	// For a connection without a deadline, or that can't be closed, deadline and closed
+	// are nil and never ready
+	var deadline <-chan time.Time
+	if !c.readDeadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.readDeadline))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case latency := <-c.hasBeenRequested:
+		return latency, nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-deadline:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// waitForReadDeadline blocks until the read deadline passes, or, without a deadline,
+// until the connection is closed
+func (c *MockConn) waitForReadDeadline() error {
+	if c.readDeadline.IsZero() {
+		<-c.closed
+		return net.ErrClosed
+	}
+	timer := time.NewTimer(time.Until(c.readDeadline))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
 func (c *MockConn) Write(b []byte) (int, error) {
-	// Mark this connect as having received a request
-	c.hasBeenRequested <- struct{}{}
-	return len(b), nil
+	n := len(b)
+	if c.fault == mockFaultShortWrite {
+		return n / 2, io.ErrShortWrite
+	}
+	// A pipelining client may write several requests at once, so keep going until
+	// every byte has been accounted for
+	for {
+		if !c.isReadingRequestBody {
+			if len(b) == 0 {
+				return n, nil
+			}
+			// The headers may be split across several writes
+			request := b
+			if len(c.requestHeaders) > 0 {
+				c.requestHeaders = append(c.requestHeaders, b...)
+				request = c.requestHeaders
+			}
+			headersEnd := bytes.Index(request, []byte("\r\n\r\n"))
+			if headersEnd < 0 {
+				if len(c.requestHeaders) == 0 {
+					c.requestHeaders = append(c.requestHeaders, b...)
+				}
+				return n, nil
+			}
+			contentLength, err := c.parseRequestHeaders(request[:headersEnd])
+			if err != nil {
+				return 0, err
+			}
+			c.isReadingRequestBody = true
+			c.remainingRequestBody = contentLength
+			b = request[headersEnd+len("\r\n\r\n"):]
+		}
+
+		// Count the body rather than keeping it, since only its length is checked
+		body := len(b)
+		if body > c.remainingRequestBody {
+			body = c.remainingRequestBody
+		}
+		c.remainingRequestBody -= body
+		b = b[body:]
+		if c.remainingRequestBody > 0 {
+			return n, nil
+		}
+		// Whatever is left of b may point into requestHeaders, which the next request
+		// reuses; append copies it over the start of the buffer safely
+		c.requestHeaders = c.requestHeaders[:0]
+		c.isReadingRequestBody = false
+
+		// Mark this connection as having received a request
+		c.hasBeenRequested <- c.requestLatency
+	}
+}
+
+// parseRequestHeaders checks the request line and returns the length of the body
+// that the headers announce. Allocating here would be counted against the client
+// being benchmarked, so the headers are scanned in place.
+func (c *MockConn) parseRequestHeaders(headers []byte) (int, error) {
+	requestLine := headers
+	if lineEnd := bytes.Index(headers, []byte("\r\n")); lineEnd >= 0 {
+		requestLine, headers = headers[:lineEnd], headers[lineEnd+len("\r\n"):]
+	} else {
+		headers = nil
+	}
+	if bytes.Count(requestLine, []byte(" ")) != 2 || !bytes.HasSuffix(requestLine, []byte(" HTTP/1.1")) {
+		return 0, fmt.Errorf("malformed request line %q", requestLine)
+	}
+
+	contentLength := 0
+	c.requestLatency = 0
+	for len(headers) > 0 {
+		line := headers
+		if lineEnd := bytes.Index(headers, []byte("\r\n")); lineEnd >= 0 {
+			line, headers = headers[:lineEnd], headers[lineEnd+len("\r\n"):]
+		} else {
+			headers = nil
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return 0, fmt.Errorf("malformed request header %q", line)
+		}
+		name, value := line[:colon], bytes.TrimSpace(line[colon+1:])
+
+		switch {
+		case bytes.EqualFold(name, []byte("Content-Length")):
+			length, err := strconv.Atoi(string(value))
+			if err != nil || length < 0 {
+				return 0, fmt.Errorf("invalid Content-Length %q", value)
+			}
+			contentLength = length
+		case bytes.EqualFold(name, []byte("Transfer-Encoding")):
+			return 0, fmt.Errorf("unsupported Transfer-Encoding %q", value)
+		case c.simulateLatency && bytes.EqualFold(name, []byte(mockLatencyHeader)):
+			if c.parsedLatencyValue == nil || !bytes.Equal(value, c.parsedLatencyValue) {
+				latency, err := time.ParseDuration(string(value))
+				if err != nil {
+					return 0, fmt.Errorf("invalid %s %q", mockLatencyHeader, value)
+				}
+				c.parsedLatencyValue = append(c.parsedLatencyValue[:0], value...)
+				c.parsedLatency = latency
+			}
+			c.requestLatency = c.parsedLatency
+		}
+	}
+	return contentLength, nil
 }
 
 func (c *MockConn) Close() error {
+	if c.closed != nil {
+		c.closeOnce.Do(func() {
+			close(c.closed)
+		})
+		return nil
+	}
 	c.numberOfBytesRead = 0
+	c.requestHeaders = c.requestHeaders[:0]
+	c.isReadingRequestBody = false
+	c.remainingRequestBody = 0
+	// Drop the requests that were never answered, so they aren't answered on the next
+	// connection that gets this MockConn
+	for len(c.hasBeenRequested) > 0 {
+		<-c.hasBeenRequested
+	}
+	// Connections go back to the shared pool in the default mode
+	c.simulateLatency = false
+	c.requestLatency = 0
+	c.response = nil
+	c.closeAfterResponse = false
+	c.maxResponses = 0
+	c.responses = 0
+	c.readDeadline = time.Time{}
 	mockServerConnectionPool.Put(c)
 	return nil
 }
@@ -71,6 +350,22 @@ var mockServerAddr = net.TCPAddr{
 	Port: 8542,
 }
 
+// Clients that have timeouts set deadlines, which only a MockConn simulating a timeout
+// needs to respect
+func (c *MockConn) SetDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func (c *MockConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func (c *MockConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
 func (c *MockConn) LocalAddr() net.Addr {
 	return &mockServerAddr
 }
@@ -79,108 +374,442 @@ func (c *MockConn) RemoteAddr() net.Addr {
 	return &mockServerAddr
 }
 
-func BenchmarkNetHttpClientToMockServer(b *testing.B) {
-	// Create an http.Client
-	client := &http.Client{
+// dialMockServer "connects" to the mock server by handing out a pooled MockConn
+func dialMockServer(addr string) (net.Conn, error) {
+	return mockServerConnectionPool.Get().(*MockConn), nil
+}
+
+// dialMockServerWithLatency hands out a MockConn that delays each response by the
+// latency that the request asks for
+func dialMockServerWithLatency(addr string) (net.Conn, error) {
+	conn := mockServerConnectionPool.Get().(*MockConn)
+	conn.simulateLatency = true
+	return conn, nil
+}
+
+// dialMockServerWithFault returns a dial function whose connections simulate the
+// fault on every request
+func dialMockServerWithFault(fault MockFault) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		return &MockConn{
+			hasBeenRequested: make(chan time.Duration, mockMaxPendingRequests),
+			response:         mockResponseWithBodySize(1 << 10),
+			fault:            fault,
+			closed:           make(chan struct{}),
+		}, nil
+	}
+}
+
+// NewMockConn creates a connection that answers every request with a generated body
+// of the given size
+func NewMockConn(bodySize int) *MockConn {
+	return &MockConn{
+		hasBeenRequested: make(chan time.Duration, mockMaxPendingRequests),
+		response:         mockResponseWithBodySize(bodySize),
+		closed:           make(chan struct{}),
+	}
+}
+
+// Generated responses, by body size, so that each is only generated once
+var mockResponses sync.Map
+
+// mockResponseWithBodySize returns a response whose body is makePayload(bodySize)
+func mockResponseWithBodySize(bodySize int) []byte {
+	if response, ok := mockResponses.Load(bodySize); ok {
+		return response.([]byte)
+	}
+	response, _ := mockResponses.LoadOrStore(bodySize, makeMockResponse("test/plain", makePayload(bodySize)))
+	return response.([]byte)
+}
+
+// dialMockServerWithBodySize returns a dial function whose connections respond with
+// a body of the given size
+func dialMockServerWithBodySize(bodySize int) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		return NewMockConn(bodySize), nil
+	}
+}
+
+// makeMockResponse builds a complete response with the given body for a MockConn
+func makeMockResponse(contentType string, body []byte) []byte {
+	response := []byte("HTTP/1.1 200 OK\r\nContent-Type: " + contentType + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
+	return append(response, body...)
+}
+
+// dialMockServerWithResponse returns a dial function whose connections answer every
+// request with the given response
+func dialMockServerWithResponse(response []byte) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn := mockServerConnectionPool.Get().(*MockConn)
+		conn.response = response
+		return conn, nil
+	}
+}
+
+// CountingDialer wraps a dial function and counts how many connections are opened
+// through it, so benchmarks can report how well a client reuses its connections
+type CountingDialer struct {
+	dials int64
+	dial  fasthttp.DialFunc
+}
+
+func NewCountingDialer(dial fasthttp.DialFunc) *CountingDialer {
+	return &CountingDialer{dial: dial}
+}
+
+// Dial matches the signature of fasthttp.Client.Dial
+func (d *CountingDialer) Dial(addr string) (net.Conn, error) {
+	atomic.AddInt64(&d.dials, 1)
+	return d.dial(addr)
+}
+
+// DialNetwork matches the signature of http.Transport.Dial
+func (d *CountingDialer) DialNetwork(network, addr string) (net.Conn, error) {
+	return d.Dial(addr)
+}
+
+func (d *CountingDialer) Count() int64 {
+	return atomic.LoadInt64(&d.dials)
+}
+
+func (d *CountingDialer) Reset() {
+	atomic.StoreInt64(&d.dials, 0)
+}
+
+// newNetHttpClientToMockServer returns an http.Client whose connections are all
+// served by a MockConn
+func newNetHttpClientToMockServer() *http.Client {
+	return &http.Client{
 		Transport: &http.Transport{
 			Dial: func(network, addr string) (net.Conn, error) {
-				return mockServerConnectionPool.Get().(*MockConn), nil
+				return dialMockServer(addr)
 			},
 			// Set the maximum number of idle connections equal to the max number of processes
 			MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
 		},
 	}
+}
 
-	testValue := "123"
-	testUrl := "http://host.test/query"
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			resp, err := client.Get(testUrl)
-			if err != nil {
-				b.Fatalf("client get failed: %s", err)
-			}
-			if resp.StatusCode != http.StatusOK {
-				b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+// newFastHttpClientToMockServer returns a fasthttp.Client whose connections are all
+// served by a MockConn
+func newFastHttpClientToMockServer() *fasthttp.Client {
+	return &fasthttp.Client{
+		Dial: dialMockServer,
+		// Set the maximum number of idle connections equal to the max number of processes
+		MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+	}
+}
+
+// The number of requests that reportClientAllocs measures one by one
+const clientAllocSamples = 100
+
+// reportClientAllocs reports the allocations made by the client alone as
+// client-allocs/op, next to the allocs/op of the whole benchmark. Once the benchmark
+// has run, the connections are already dialed and pooled, so it makes requests one
+// at a time with the timer stopped and snapshots the allocation count around each.
+// The metric comes from that serial run rather than from the parallel one above it,
+// so it leaves out any allocations that contention for the pool causes. The MockConn
+// doesn't allocate while serving a request, which TestMockConnDoesNotAllocate checks,
+// so what's left is the client's own.
+func reportClientAllocs(b *testing.B, doRequest func(buffer []byte) (statusCode int, body []byte, err error)) {
+	b.StopTimer()
+	defer b.StartTimer()
+
+	var before, after runtime.MemStats
+	var buffer []byte
+	var allocs uint64
+	for i := 0; i < clientAllocSamples; i++ {
+		runtime.ReadMemStats(&before)
+		_, body, err := doRequest(buffer)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			b.Fatalf("client request failed: %s", err)
+		}
+		// Skip the first request, which grows the buffer that the others reuse
+		if i > 0 {
+			allocs += after.Mallocs - before.Mallocs
+		}
+		buffer = body
+	}
+	b.ReportMetric(float64(allocs)/float64(clientAllocSamples-1), "client-allocs/op")
+}
+
+func TestMockConnDoesNotAllocate(t *testing.T) {
+	// The requests that the benchmarks send: a GET as net/http writes it, with a
+	// latency for the MockConn to simulate, and a POST with a body
+	requests := [][]byte{
+		[]byte("GET /query HTTP/1.1\r\nHost: host.test\r\nUser-Agent: Go-http-client/1.1\r\n" + mockLatencyHeader + ": 0s\r\nAccept-Encoding: gzip\r\n\r\n"),
+		[]byte("POST /upload HTTP/1.1\r\nHost: host.test\r\nContent-Type: application/json\r\nContent-Length: 3\r\n\r\n123"),
+	}
+
+	for _, bodySize := range mockResponseBodySizes {
+		conn := NewMockConn(bodySize)
+		conn.simulateLatency = true
+		buffer := make([]byte, 4<<10)
+
+		for _, request := range requests {
+			// Split the request across two writes, so the MockConn has to keep the
+			// start of its headers
+			split := len(request) / 2
+			allocs := testing.AllocsPerRun(100, func() {
+				for _, b := range [][]byte{request[:split], request[split:]} {
+					if _, err := conn.Write(b); err != nil {
+						t.Fatalf("write failed: %s", err)
+					}
+				}
+				for n := 0; n < len(conn.response); {
+					read, err := conn.Read(buffer)
+					if err != nil {
+						t.Fatalf("read failed: %s", err)
+					}
+					n += read
+				}
+			})
+			if allocs != 0 {
+				t.Fatalf("expected a MockConn with a body of %d bytes to serve %q without allocating but it took %.0f allocations", bodySize, request[:bytes.IndexByte(request, '\r')], allocs)
 			}
-			// Read the response body
-			body, err := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			if err != nil {
-				b.Fatalf("error while reading response body: %s", err)
+		}
+	}
+}
+
+// Response bodies from a few bytes of JSON up to a large download
+var mockResponseBodySizes = []int{1 << 10, 64 << 10, 1 << 20}
+
+func BenchmarkNetHttpClientToMockServer(b *testing.B) {
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makePayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			dial := dialMockServerWithBodySize(bodySize)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
 			}
-			if string(body) != testValue {
-				b.Fatalf("expected body %q but got %q", testValue, body)
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(bodySize))
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
 			}
-		}
-	})
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
 }
 
 func BenchmarkFastHttpClientToMockServer(b *testing.B) {
-	// Create a client
-	client := &fasthttp.Client{
-		Dial: func(addr string) (net.Conn, error) {
-			return mockServerConnectionPool.Get().(*MockConn), nil
-		},
-		// Set the maximum number of idle connections equal to the max number of processes
-		MaxConnsPerHost: runtime.GOMAXPROCS(-1),
-	}
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makePayload(bodySize)
 
-	testValue := "123"
-	testUrl := "http://host.test/query"
-	b.RunParallel(func(pb *testing.PB) {
-		var buffer []byte
-		for pb.Next() {
-			statusCode, body, err := client.Get(buffer, testUrl)
-			if err != nil {
-				b.Fatalf("client get failed: %s", err)
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithBodySize(bodySize),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
 			}
-			if statusCode != fasthttp.StatusOK {
-				b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
-			}
-			if string(body) != testValue {
-				b.Fatalf("expected body %q but got %q", testValue, body)
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(bodySize))
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
 			}
-			buffer = body
-		}
-	})
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
+	}
 }
 
 func BenchmarkFastHttpClientWithManagedBuffersToMockServer(b *testing.B) {
-	// Create a client
-	client := &fasthttp.Client{
-		Dial: func(addr string) (net.Conn, error) {
-			return mockServerConnectionPool.Get().(*MockConn), nil
-		},
-		// Set the maximum number of idle connections equal to the max number of processes
-		MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+	for _, bodySize := range mockResponseBodySizes {
+		testValue := makePayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerWithBodySize(bodySize),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://host.test/query"
+			b.SetBytes(int64(bodySize))
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Acquire a request instance
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+
+				// Acquire a response instance
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				err := client.Do(req, resp)
+				// The body belongs to the response, which is about to be released, so copy
+				// it into the buffer that the benchmark hands back each time
+				return resp.StatusCode(), append(buffer[:0], resp.Body()...), err
+			}
+			runClientBenchmark(b, doRequest, testValue)
+			reportClientAllocs(b, doRequest)
+		})
 	}
+}
 
-	testValue := []byte("123")
-	testUrl := "http://host.test/query"
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			// Acquire a request instance
-			req := fasthttp.AcquireRequest()
-			req.SetRequestURI(testUrl)
+// Request bodies smaller and larger than the 4KB write buffer that both clients use
+// by default, so that the larger one takes several writes to the connection
+var mockPostBodySizes = []int{1 << 10, 64 << 10}
 
-			// Acquire a response instance
-			resp := fasthttp.AcquireResponse()
+// makeJsonPayload generates a JSON object of exactly the given size
+func makeJsonPayload(size int) []byte {
+	const prefix, suffix = "{\"data\":\"", "\"}"
+	payload := make([]byte, 0, size)
+	payload = append(payload, prefix...)
+	payload = append(payload, makePayload(size-len(prefix)-len(suffix))...)
+	return append(payload, suffix...)
+}
 
-			err := client.Do(req, resp)
-			if err != nil {
-				b.Fatalf("client get failed: %s", err)
+// WriteCountingConn counts the writes made to a connection, which shows how many
+// system calls a request would take on a real socket
+type WriteCountingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c WriteCountingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// dialMockServerCountingWrites returns a dial function whose connections add their
+// writes to the counter
+func dialMockServerCountingWrites(writes *int64) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dialMockServer(addr)
+		return WriteCountingConn{Conn: conn, writes: writes}, err
+	}
+}
+
+func BenchmarkNetHttpClientPostToMockServer(b *testing.B) {
+	for _, bodySize := range mockPostBodySizes {
+		payload := makeJsonPayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			var writes int64
+			dial := dialMockServerCountingWrites(&writes)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					// Set the maximum number of idle connections equal to the max number of processes
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testValue := []byte("123")
+			testUrl := "http://host.test/upload"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				req, err := http.NewRequest(http.MethodPost, testUrl, bytes.NewReader(payload))
+				if err != nil {
+					return 0, nil, err
+				}
+				req.Header.Set("Content-Type", "application/json")
+				return netHttpResult(client.Do(req))
 			}
-			if resp.StatusCode() != fasthttp.StatusOK {
-				b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			runClientBenchmark(b, doRequest, testValue)
+
+			b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+func BenchmarkFastHttpClientPostToMockServer(b *testing.B) {
+	for _, bodySize := range mockPostBodySizes {
+		payload := makeJsonPayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			var writes int64
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerCountingWrites(&writes),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
 			}
-			body := resp.Body()
-			if !bytes.Equal(body, testValue) {
-				b.Fatalf("expected body %q but got %q", testValue, body)
+
+			testValue := []byte("123")
+			testUrl := "http://host.test/upload"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Post takes form arguments, so use a request that can carry a JSON body
+				req := &fasthttp.Request{}
+				req.SetRequestURI(testUrl)
+				req.Header.SetMethod(fasthttp.MethodPost)
+				req.Header.SetContentType("application/json")
+				req.SetBody(payload)
+
+				resp := &fasthttp.Response{}
+				err := client.Do(req, resp)
+				return resp.StatusCode(), resp.Body(), err
 			}
+			runClientBenchmark(b, doRequest, testValue)
 
-			// Release the request and response
-			fasthttp.ReleaseRequest(req)
-			fasthttp.ReleaseResponse(resp)
-		}
-	})
+			b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+			reportClientAllocs(b, doRequest)
+		})
+	}
+}
+
+func BenchmarkFastHttpClientPostWithManagedBuffersToMockServer(b *testing.B) {
+	for _, bodySize := range mockPostBodySizes {
+		payload := makeJsonPayload(bodySize)
+
+		b.Run(fmt.Sprintf("size=%dKB", bodySize>>10), func(b *testing.B) {
+			var writes int64
+			// Create a client
+			client := &fasthttp.Client{
+				Dial: dialMockServerCountingWrites(&writes),
+				// Set the maximum number of idle connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testValue := []byte("123")
+			testUrl := "http://host.test/upload"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			doRequest := func(buffer []byte) (int, []byte, error) {
+				// Acquire a request instance
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				req.Header.SetMethod(fasthttp.MethodPost)
+				req.Header.SetContentType("application/json")
+				req.SetBody(payload)
+
+				// Acquire a response instance
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				err := client.Do(req, resp)
+				// The body belongs to the response, which is about to be released, so copy
+				// it into the buffer that the benchmark hands back each time
+				return resp.StatusCode(), append(buffer[:0], resp.Body()...), err
+			}
+			runClientBenchmark(b, doRequest, testValue)
+
+			b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+			reportClientAllocs(b, doRequest)
+		})
+	}
 }
@@ -0,0 +1,149 @@
+package fasthttp_request_perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/metrics"
+	"testing"
+	"unsafe"
+
+	"github.com/valyala/fasthttp"
+)
+
+// stackMoved makes a request and reports whether the calling goroutine's stack was
+// copied while doing so. The runtime grows a stack by copying it to a larger one,
+// which moves every local variable, so comparing the address of a local variable
+// before and after the request detects the copy.
+//
+//go:noinline
+func stackMoved(doRequest func() error) (bool, error) {
+	var marker byte
+	before := uintptr(unsafe.Pointer(&marker))
+	err := doRequest()
+	after := uintptr(unsafe.Pointer(&marker))
+	return before != after, err
+}
+
+// startingStackSize is the size of the stack that new goroutines are given
+func startingStackSize() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/stack/starting-size:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// benchmarkStackGrowth compares making each request on a new goroutine, whose small
+// starting stack may have to be copied to a larger one during the request, against
+// making every request on one long-lived goroutine, whose stack only grows once.
+// Both hand the request off over a channel so that only the goroutine differs.
+func benchmarkStackGrowth(b *testing.B, doRequest func() error) {
+	type result struct {
+		moved bool
+		err   error
+	}
+
+	b.Run("new-goroutine", func(b *testing.B) {
+		done := make(chan result)
+		stackCopies := 0
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			go func() {
+				moved, err := stackMoved(doRequest)
+				done <- result{moved, err}
+			}()
+			r := <-done
+			if r.err != nil {
+				b.Fatalf("request failed: %s", r.err)
+			}
+			if r.moved {
+				stackCopies++
+			}
+		}
+
+		b.ReportMetric(float64(stackCopies)/float64(b.N), "stack-copies/op")
+		b.ReportMetric(float64(startingStackSize()), "starting-stack-B")
+	})
+
+	b.Run("reused-goroutine", func(b *testing.B) {
+		work := make(chan struct{})
+		done := make(chan result)
+		go func() {
+			for range work {
+				moved, err := stackMoved(doRequest)
+				done <- result{moved, err}
+			}
+		}()
+		defer close(work)
+
+		stackCopies := 0
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			work <- struct{}{}
+			r := <-done
+			if r.err != nil {
+				b.Fatalf("request failed: %s", r.err)
+			}
+			if r.moved {
+				stackCopies++
+			}
+		}
+
+		b.ReportMetric(float64(stackCopies)/float64(b.N), "stack-copies/op")
+	})
+}
+
+func BenchmarkRequestStackGrowth(b *testing.B) {
+	testValue := "123"
+	testUrl := "http://host.test/query"
+
+	b.Run("NetHttp", func(b *testing.B) {
+		client := newNetHttpClientToMockServer()
+		benchmarkStackGrowth(b, func() error {
+			resp, err := client.Get(testUrl)
+			if err != nil {
+				return fmt.Errorf("client get failed: %s", err)
+			}
+			// Read the response body
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("error while reading response body: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if string(body) != testValue {
+				return fmt.Errorf("expected body %q but got %q", testValue, body)
+			}
+			return nil
+		})
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		client := newFastHttpClientToMockServer()
+		benchmarkStackGrowth(b, func() error {
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			if err := client.Do(req, resp); err != nil {
+				return fmt.Errorf("client get failed: %s", err)
+			}
+			if resp.StatusCode() != fasthttp.StatusOK {
+				return fmt.Errorf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+			}
+			if string(resp.Body()) != testValue {
+				return fmt.Errorf("expected body %q but got %q", testValue, resp.Body())
+			}
+			return nil
+		})
+	})
+}
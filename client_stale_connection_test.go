@@ -0,0 +1,133 @@
+package fasthttp_request_perf
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The server closes keep-alive connections that have been idle for longer than this
+const staleConnectionIdleTimeout = 50 * time.Millisecond
+
+// How long to wait for the server to drop the idle connection before giving up, which
+// is far longer than it should ever take
+const staleConnectionCloseTimeout = 10 * time.Second
+
+// startStaleConnectionServer starts a server that drops idle connections, and returns
+// a channel that receives whenever it has closed one
+func startStaleConnectionServer(t *testing.T) (*TcpServer, <-chan struct{}) {
+	closed := make(chan struct{}, 1)
+	server := startTcpServerWithServer(t, &fasthttp.Server{
+		Handler:     handleRequest,
+		IdleTimeout: staleConnectionIdleTimeout,
+		ConnState: func(conn net.Conn, state fasthttp.ConnState) {
+			if state != fasthttp.StateClosed {
+				return
+			}
+			// Don't block the server on closes that nobody is waiting for
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		},
+	})
+	return server, closed
+}
+
+// waitForServerClose waits until the server has closed the idle connection, rather
+// than sleeping for longer than its idle timeout and hoping that it has
+func waitForServerClose(t *testing.T, closed <-chan struct{}) {
+	select {
+	case <-closed:
+	case <-time.After(staleConnectionCloseTimeout):
+		t.Fatalf("expected the server to close the idle connection within %s", staleConnectionCloseTimeout)
+	}
+}
+
+func TestClientHandlesStaleConnection(t *testing.T) {
+	testValue := "123"
+
+	t.Run("NetHttp", func(t *testing.T) {
+		server, closed := startStaleConnectionServer(t)
+		defer server.Stop(t)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: dialer.DialNetwork,
+			},
+		}
+		testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+
+		get := func() {
+			resp, err := client.Get(testUrl)
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("error while reading response body: %s", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		}
+
+		// The first request leaves an idle connection in the pool, which the server then drops
+		get()
+		waitForServerClose(t, closed)
+
+		// net/http has a background goroutine reading from every pooled connection,
+		// so it notices the server closing the connection and evicts it from the pool.
+		// If the next request gets there first, it fails before anything is sent and is
+		// retried on a new connection, so either way there's a second dial.
+		get()
+		if dials := dialer.Count(); dials != 2 {
+			t.Fatalf("expected the stale connection to be replaced by a second dial but got %d dials", dials)
+		}
+	})
+
+	t.Run("FastHttp", func(t *testing.T) {
+		server, closed := startStaleConnectionServer(t)
+		defer server.Stop(t)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := &fasthttp.Client{
+			Dial: dialer.Dial,
+		}
+		testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+
+		get := func() {
+			statusCode, body, err := client.Get(nil, testUrl)
+			if err != nil {
+				t.Fatalf("client get failed: %s", err)
+			}
+			if statusCode != fasthttp.StatusOK {
+				t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+			}
+			if string(body) != testValue {
+				t.Fatalf("expected body %q but got %q", testValue, body)
+			}
+		}
+
+		// The first request leaves an idle connection in the pool, which the server then drops
+		get()
+		waitForServerClose(t, closed)
+
+		// fasthttp doesn't watch its idle connections, so the request is written to the
+		// stale connection and fails when reading the response. Because GET is idempotent,
+		// the client transparently retries it on a freshly dialed connection.
+		get()
+		if dials := dialer.Count(); dials != 2 {
+			t.Fatalf("expected the stale connection to be replaced by a second dial but got %d dials", dials)
+		}
+	})
+}
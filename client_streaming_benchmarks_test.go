@@ -0,0 +1,689 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HeapSampler tracks the largest heap observed during a benchmark, relative to
+// the heap in use when the sampler was created
+type HeapSampler struct {
+	baseline uint64
+	peak     uint64
+}
+
+func NewHeapSampler() *HeapSampler {
+	// Collect garbage first so that the baseline reflects only live memory
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &HeapSampler{baseline: m.HeapAlloc, peak: m.HeapAlloc}
+}
+
+// Sample reads the current heap size. Reading memory stats stops the world, so
+// the benchmark timer is paused while sampling.
+func (s *HeapSampler) Sample(b *testing.B) {
+	b.StopTimer()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc > s.peak {
+		s.peak = m.HeapAlloc
+	}
+	b.StartTimer()
+}
+
+func (s *HeapSampler) Report(b *testing.B) {
+	b.ReportMetric(float64(s.peak-s.baseline), "peak-heap-B")
+}
+
+// Responses with a larger body than this are streamed rather than buffered. It's
+// below the smallest body that any of the benchmarks stream.
+const streamingThreshold = 32 << 10
+
+// isBodyStreamed reports whether the response body is read from the connection as it
+// arrives. A client with StreamResponseBody set still buffers a body that's no larger
+// than its MaxResponseBodySize, and then BodyStream only reads from that buffer.
+func isBodyStreamed(resp *fasthttp.Response) bool {
+	if !resp.IsBodyStream() {
+		return false
+	}
+	_, buffered := resp.BodyStream().(*bytes.Reader)
+	return !buffered
+}
+
+func BenchmarkFastHttpClientStreamVsBufferLargeResponse(b *testing.B) {
+	testValue := makePayload(10 << 20)
+
+	for _, streamResponseBody := range []bool{false, true} {
+		name := "buffered"
+		if streamResponseBody {
+			name = "streamed"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleFixedBodyRequest(testValue))
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				StreamResponseBody: streamResponseBody,
+			}
+			if streamResponseBody {
+				// fasthttp still buffers responses with a Content-Length unless the body is
+				// larger than MaxResponseBodySize, which then acts as the streaming threshold
+				client.MaxResponseBodySize = streamingThreshold
+			}
+
+			testUrl := "http://" + server.hostAddress + "/large"
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+
+			// A fixed-size buffer into which the streamed body is read
+			chunk := make([]byte, 32<<10)
+
+			heap := NewHeapSampler()
+			b.SetBytes(int64(len(testValue)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp := fasthttp.AcquireResponse()
+
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+
+				if !streamResponseBody {
+					// The whole body is held in memory now
+					heap.Sample(b)
+					if !bytes.Equal(resp.Body(), testValue) {
+						b.Fatalf("expected body of %d bytes but got %d bytes that don't match", len(testValue), len(resp.Body()))
+					}
+				} else {
+					if !isBodyStreamed(resp) {
+						b.Fatalf("expected the body to be streamed")
+					}
+					// Compare each chunk against the matching part of the expected body
+					bodyStream := resp.BodyStream()
+					offset := 0
+					sampled := false
+					for {
+						n, err := bodyStream.Read(chunk)
+						if n > 0 {
+							if offset+n > len(testValue) || !bytes.Equal(chunk[:n], testValue[offset:offset+n]) {
+								b.Fatalf("streamed body differs from the expected body at offset %d", offset)
+							}
+							offset += n
+						}
+						// Midway through the body is representative of what streaming holds in memory
+						if !sampled && offset >= len(testValue)/2 {
+							heap.Sample(b)
+							sampled = true
+						}
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							b.Fatalf("error while reading response body: %s", err)
+						}
+					}
+					if offset != len(testValue) {
+						b.Fatalf("expected body of %d bytes but got %d bytes", len(testValue), offset)
+					}
+					resp.CloseBodyStream()
+				}
+
+				fasthttp.ReleaseResponse(resp)
+			}
+
+			heap.Report(b)
+		})
+	}
+}
+
+// handleChunkedRequest streams a long chunked response from /chunked and otherwise
+// behaves like handleRequest
+func handleChunkedRequest(ctx *fasthttp.RequestCtx) {
+	if string(ctx.Path()) != "/chunked" {
+		handleRequest(ctx)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	// A body stream without a known size is sent with chunked encoding
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		for i := 0; i < chunkedResponseChunks; i++ {
+			w.Write(chunkedResponseChunk)
+			// Flushing sends each write as its own chunk. It fails once the client
+			// has closed the connection.
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// The chunked response is far longer than the part the client reads before closing
+const (
+	chunkedResponseChunkSize = 1 << 10
+	chunkedResponseChunks    = 1000
+)
+
+var chunkedResponseChunk = makePayload(chunkedResponseChunkSize)
+
+// readFirstChunk reads only the beginning of a streamed body and verifies it
+func readFirstChunk(tb testing.TB, body io.Reader) {
+	firstChunk := make([]byte, chunkedResponseChunkSize)
+	if _, err := io.ReadFull(body, firstChunk); err != nil {
+		tb.Fatalf("error while reading the first chunk: %s", err)
+	}
+	if !bytes.Equal(firstChunk, chunkedResponseChunk) {
+		tb.Fatalf("first chunk %q doesn't match the expected chunk", firstChunk)
+	}
+}
+
+// getChunkedAndCloseEarlyWithNetHttp reads the first chunk of the response and then
+// closes the body without draining it, which tells net/http to discard the connection
+func getChunkedAndCloseEarlyWithNetHttp(tb testing.TB, client *http.Client, testUrl string) {
+	resp, err := client.Get(testUrl)
+	if err != nil {
+		tb.Fatalf("client get failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		tb.Fatalf("expected a chunked response but got transfer encoding %q", resp.TransferEncoding)
+	}
+	readFirstChunk(tb, resp.Body)
+}
+
+// getChunkedAndCloseEarlyWithFastHttp reads the first chunk of the streamed response
+// and then closes the stream.
+//
+// Closing a body stream returns the connection to the pool even if unread chunks are
+// still in flight, and the next request on it fails to parse the leftovers as a
+// response, as the FastHttp/keep-alive test shows. So a request that may be abandoned
+// early must ask for the connection to be closed, which makes closing the stream
+// discard the connection instead.
+func getChunkedAndCloseEarlyWithFastHttp(tb testing.TB, client *fasthttp.Client, testUrl string, connectionClose bool) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(testUrl)
+	if connectionClose {
+		req.SetConnectionClose()
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := client.Do(req, resp)
+	if err != nil {
+		tb.Fatalf("client get failed: %s", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		tb.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+	}
+	if resp.Header.ContentLength() != -1 {
+		tb.Fatalf("expected a chunked response but got content length %d", resp.Header.ContentLength())
+	}
+	readFirstChunk(tb, resp.BodyStream())
+	if err := resp.CloseBodyStream(); err != nil {
+		tb.Fatalf("error while closing the body stream: %s", err)
+	}
+}
+
+// newFastHttpClientForEarlyClose creates a client that streams response bodies.
+// Retries are disabled so that reusing a connection with leftover data would fail the
+// next request rather than being hidden by a transparent retry.
+func newFastHttpClientForEarlyClose(dialer *CountingDialer) *fasthttp.Client {
+	return &fasthttp.Client{
+		Dial:                      dialer.Dial,
+		StreamResponseBody:        true,
+		MaxIdemponentCallAttempts: 1,
+	}
+}
+
+func TestClientEarlyCloseChunkedResponse(t *testing.T) {
+	testValue := "123"
+
+	t.Run("NetHttp", func(t *testing.T) {
+		server := startTcpServerWithHandler(t, handleChunkedRequest)
+		defer server.Stop(t)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: dialer.DialNetwork,
+			},
+		}
+
+		getChunkedAndCloseEarlyWithNetHttp(t, client, "http://"+server.hostAddress+"/chunked")
+
+		// The next request must get a fresh connection
+		resp, err := client.Get("http://" + server.hostAddress + "/query?q=" + testValue)
+		if err != nil {
+			t.Fatalf("client get after early close failed: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error while reading response body: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+		}
+		if string(body) != testValue {
+			t.Fatalf("expected body %q but got %q", testValue, body)
+		}
+		if dials := dialer.Count(); dials != 2 {
+			t.Fatalf("expected the early-closed connection to be discarded but got %d dials", dials)
+		}
+	})
+
+	t.Run("FastHttp/connection-close", func(t *testing.T) {
+		server := startTcpServerWithHandler(t, handleChunkedRequest)
+		defer server.Stop(t)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := newFastHttpClientForEarlyClose(dialer)
+
+		getChunkedAndCloseEarlyWithFastHttp(t, client, "http://"+server.hostAddress+"/chunked", true)
+
+		// The next request must get a fresh connection
+		statusCode, body, err := client.Get(nil, "http://"+server.hostAddress+"/query?q="+testValue)
+		if err != nil {
+			t.Fatalf("client get after early close failed: %s", err)
+		}
+		if statusCode != fasthttp.StatusOK {
+			t.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+		}
+		if string(body) != testValue {
+			t.Fatalf("expected body %q but got %q", testValue, body)
+		}
+		if dials := dialer.Count(); dials != 2 {
+			t.Fatalf("expected the early-closed connection to be discarded but got %d dials", dials)
+		}
+	})
+
+	// Without Connection: close, fasthttp v1.46.0 puts the half-read connection back in
+	// its pool, and the next request reads the rest of the chunks as its response. This
+	// subtest pins that down. If it fails because the next request succeeds, fasthttp
+	// has fixed it, and getChunkedAndCloseEarlyWithFastHttp no longer needs to close the
+	// connection.
+	t.Run("FastHttp/keep-alive", func(t *testing.T) {
+		server := startTcpServerWithHandler(t, handleChunkedRequest)
+		defer server.Stop(t)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := newFastHttpClientForEarlyClose(dialer)
+
+		getChunkedAndCloseEarlyWithFastHttp(t, client, "http://"+server.hostAddress+"/chunked", false)
+
+		statusCode, body, err := client.Get(nil, "http://"+server.hostAddress+"/query?q="+testValue)
+		if err == nil && statusCode == fasthttp.StatusOK && string(body) == testValue {
+			t.Fatalf("expected the next request to fail on the half-read connection, but it got a clean body after %d dials", dialer.Count())
+		}
+		if dials := dialer.Count(); dials != 1 {
+			t.Fatalf("expected the next request to reuse the half-read connection but got %d dials", dials)
+		}
+	})
+}
+
+// BenchmarkClientEarlyCloseChunkedResponse measures abandoning chunked responses.
+// Every request needs a new connection, so dials/op should be exactly 1.
+func BenchmarkClientEarlyCloseChunkedResponse(b *testing.B) {
+	b.Run("NetHttp", func(b *testing.B) {
+		server := startTcpServerWithHandler(b, handleChunkedRequest)
+		defer server.Stop(b)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: dialer.DialNetwork,
+			},
+		}
+
+		testUrl := "http://" + server.hostAddress + "/chunked"
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			getChunkedAndCloseEarlyWithNetHttp(b, client, testUrl)
+		}
+		b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+	})
+
+	b.Run("FastHttp", func(b *testing.B) {
+		server := startTcpServerWithHandler(b, handleChunkedRequest)
+		defer server.Stop(b)
+
+		dialer := NewCountingDialer(fasthttp.Dial)
+		client := newFastHttpClientForEarlyClose(dialer)
+
+		testUrl := "http://" + server.hostAddress + "/chunked"
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			getChunkedAndCloseEarlyWithFastHttp(b, client, testUrl, true)
+		}
+		b.ReportMetric(float64(dialer.Count())/float64(b.N), "dials/op")
+	})
+}
+
+// streamsEqual compares two streams chunk by chunk, so that neither has to be held in
+// memory in full. Both buffers must be the same size.
+func streamsEqual(a, b io.Reader, bufferA, bufferB []byte) (bool, error) {
+	for {
+		nA, errA := io.ReadFull(a, bufferA)
+		nB, errB := io.ReadFull(b, bufferB)
+		if !bytes.Equal(bufferA[:nA], bufferB[:nB]) {
+			return false, nil
+		}
+
+		// A short read means the stream has ended
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
+
+// handleBodyEqualityRequest serves the expected body from /match and a copy that
+// differs in its last byte from /mismatch
+func handleBodyEqualityRequest(expected []byte) fasthttp.RequestHandler {
+	mismatching := append([]byte{}, expected...)
+	mismatching[len(mismatching)-1]++
+
+	matchHandler := handleFixedBodyRequest(expected)
+	mismatchHandler := handleFixedBodyRequest(mismatching)
+	return func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/mismatch" {
+			mismatchHandler(ctx)
+			return
+		}
+		matchHandler(ctx)
+	}
+}
+
+func BenchmarkClientStreamingBodyEquality(b *testing.B) {
+	for _, bodySize := range []int{64 << 10, 4 << 20} {
+		expected := makePayload(bodySize)
+
+		for _, streaming := range []bool{true, false} {
+			name := fmt.Sprintf("buffered/size=%dKB", bodySize>>10)
+			if streaming {
+				name = fmt.Sprintf("streaming/size=%dKB", bodySize>>10)
+			}
+
+			b.Run("NetHttp/"+name, func(b *testing.B) {
+				// Start a server
+				server := startTcpServerWithHandler(b, handleBodyEqualityRequest(expected))
+				defer server.Stop(b)
+
+				// A transport of its own, so no connection to an earlier server is reused
+				client := &http.Client{Transport: &http.Transport{}}
+				bufferA := make([]byte, 32<<10)
+				bufferB := make([]byte, 32<<10)
+
+				// bodyMatches compares the response body against the expected stream
+				bodyMatches := func(path string) bool {
+					resp, err := client.Get("http://" + server.hostAddress + path)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					defer resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+
+					expectedStream := bytes.NewReader(expected)
+					if streaming {
+						equal, err := streamsEqual(resp.Body, expectedStream, bufferA, bufferB)
+						if err != nil {
+							b.Fatalf("error while comparing response body: %s", err)
+						}
+						return equal
+					}
+
+					body, err := ioutil.ReadAll(resp.Body)
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					expectedBody, _ := ioutil.ReadAll(expectedStream)
+					return bytes.Equal(body, expectedBody)
+				}
+
+				if bodyMatches("/mismatch") {
+					b.Fatalf("expected a mismatching body to compare unequal")
+				}
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if !bodyMatches("/match") {
+						b.Fatalf("expected a matching body to compare equal")
+					}
+				}
+			})
+
+			b.Run("FastHttp/"+name, func(b *testing.B) {
+				// Start a server
+				server := startTcpServerWithHandler(b, handleBodyEqualityRequest(expected))
+				defer server.Stop(b)
+
+				client := &fasthttp.Client{
+					StreamResponseBody: streaming,
+				}
+				if streaming {
+					client.MaxResponseBodySize = streamingThreshold
+				}
+				bufferA := make([]byte, 32<<10)
+				bufferB := make([]byte, 32<<10)
+
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+
+				// bodyMatches compares the response body against the expected stream
+				bodyMatches := func(path string) bool {
+					req.SetRequestURI("http://" + server.hostAddress + path)
+					resp := fasthttp.AcquireResponse()
+					defer fasthttp.ReleaseResponse(resp)
+
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+
+					expectedStream := bytes.NewReader(expected)
+					if streaming {
+						if !isBodyStreamed(resp) {
+							b.Fatalf("expected the body to be streamed")
+						}
+						defer resp.CloseBodyStream()
+						equal, err := streamsEqual(resp.BodyStream(), expectedStream, bufferA, bufferB)
+						if err != nil {
+							b.Fatalf("error while comparing response body: %s", err)
+						}
+						return equal
+					}
+
+					expectedBody, _ := ioutil.ReadAll(expectedStream)
+					return bytes.Equal(resp.Body(), expectedBody)
+				}
+
+				if bodyMatches("/mismatch") {
+					b.Fatalf("expected a mismatching body to compare unequal")
+				}
+
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if !bodyMatches("/match") {
+						b.Fatalf("expected a matching body to compare equal")
+					}
+				}
+			})
+		}
+	}
+}
+
+// readInChunks reads the body through a reused buffer and compares each chunk against
+// the matching part of the expected body, so the body is never held in memory whole
+func readInChunks(body io.Reader, chunk []byte, expected []byte) error {
+	offset := 0
+	for {
+		n, err := body.Read(chunk)
+		if n > 0 {
+			if offset+n > len(expected) || !bytes.Equal(chunk[:n], expected[offset:offset+n]) {
+				return fmt.Errorf("streamed body differs from the expected body at offset %d", offset)
+			}
+			offset += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading response body: %s", err)
+		}
+	}
+	if offset != len(expected) {
+		return fmt.Errorf("expected body of %d bytes but got %d bytes", len(expected), offset)
+	}
+	return nil
+}
+
+func BenchmarkClientStreamingToMockServer(b *testing.B) {
+	bodySize := 1 << 20
+	testValue := makePayload(bodySize)
+	testUrl := "http://host.test/large"
+
+	for _, streaming := range []bool{false, true} {
+		name := "buffered"
+		if streaming {
+			name = "streamed"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			dial := dialMockServerWithBodySize(bodySize)
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+				},
+			}
+
+			// A fixed-size buffer into which the streamed body is read
+			chunk := make([]byte, 32<<10)
+
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+
+				if !streaming {
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if !bytes.Equal(body, testValue) {
+						b.Fatalf("expected body of %d bytes but got %d bytes that don't match", len(testValue), len(body))
+					}
+				} else if err := readInChunks(resp.Body, chunk, testValue); err != nil {
+					b.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Create a client
+			client := &fasthttp.Client{
+				Dial:               dialMockServerWithBodySize(bodySize),
+				StreamResponseBody: streaming,
+			}
+			if streaming {
+				// fasthttp still buffers responses with a Content-Length unless the body is
+				// larger than MaxResponseBodySize, which then acts as the streaming threshold
+				client.MaxResponseBodySize = streamingThreshold
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI(testUrl)
+
+			// A fixed-size buffer into which the streamed body is read
+			chunk := make([]byte, 32<<10)
+
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp := fasthttp.AcquireResponse()
+
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+
+				if !streaming {
+					// Pooled responses keep their body buffers, so buffering only allocates on
+					// the first request, but each pooled response holds on to the whole body
+					if !bytes.Equal(resp.Body(), testValue) {
+						b.Fatalf("expected body of %d bytes but got %d bytes that don't match", len(testValue), len(resp.Body()))
+					}
+				} else {
+					if !isBodyStreamed(resp) {
+						b.Fatalf("expected the body to be streamed")
+					}
+					if err := readInChunks(resp.BodyStream(), chunk, testValue); err != nil {
+						b.Fatal(err)
+					}
+					// Closing the stream lets the client reuse the connection
+					resp.CloseBodyStream()
+				}
+
+				fasthttp.ReleaseResponse(resp)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package fasthttp_request_perf
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,28 +34,72 @@ func handleRequest(ctx *fasthttp.RequestCtx) {
 	ctx.Write(args.Peek("q"))
 }
 
-func startTcpServer(b *testing.B) *TcpServer {
-	hostAddress := "127.0.0.1:8542"
+// makePayload generates a body of the given size. The contents don't matter
+// as long as corruption would be detected.
+func makePayload(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte('a' + i%26)
+	}
+	return payload
+}
+
+// handleFixedBodyRequest returns a handler that always responds with the given body
+func handleFixedBodyRequest(body []byte) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		// Avoid copying the body so that the server adds as little overhead as possible
+		ctx.Response.SetBodyRaw(body)
+	}
+}
+
+func startTcpServer(tb testing.TB) *TcpServer {
+	return startTcpServerWithHandler(tb, handleRequest)
+}
+
+func startTcpServerWithHandler(tb testing.TB, handler fasthttp.RequestHandler) *TcpServer {
+	return startTcpServerWithServer(tb, &fasthttp.Server{
+		Handler: handler,
+	})
+}
+
+// startTcpServerWithServer starts serving with an explicitly configured server,
+// for benchmarks that depend on server options such as timeouts or limits
+func startTcpServerWithServer(tb testing.TB, server *fasthttp.Server) *TcpServer {
+	return startServerOnListener(tb, listenTcp(tb), server.Serve)
+}
+
+func listenTcp(tb testing.TB) net.Listener {
+	return listenTcpOn(tb, "127.0.0.1:8542")
+}
 
+// listenTcpOn listens on another address, for benchmarks that need a second server
+// such as a proxy in front of the first
+func listenTcpOn(tb testing.TB, hostAddress string) net.Listener {
 	// Start listening for connections
 	tcpListener, err := net.Listen("tcp4", hostAddress)
 	if err != nil {
-		b.Fatalf("cannot listen on %q: %s", hostAddress, err)
+		tb.Fatalf("cannot listen on %q: %s", hostAddress, err)
 	}
+	return tcpListener
+}
 
+// startServerOnListener runs serve, which must serve connections from tcpListener
+// until the listener is closed
+func startServerOnListener(tb testing.TB, tcpListener net.Listener, serve func(net.Listener) error) *TcpServer {
 	// Use a channel to communicate if the server closes
 	isRunningChannel := make(chan struct{})
 
 	s := &TcpServer{
-		hostAddress:      hostAddress,
+		hostAddress:      tcpListener.Addr().String(),
 		tcpListener:      tcpListener,
 		isRunningChannel: isRunningChannel,
 	}
 
 	// Run the server as a goroutine because we need it to operate concurrently with the client
 	go func() {
-		if err := fasthttp.Serve(tcpListener, handleRequest); err != nil {
-			b.Fatalf("error from starting server: %s", err)
+		if err := serve(tcpListener); err != nil {
+			tb.Fatalf("error from starting server: %s", err)
 		}
 		close(isRunningChannel)
 	}()
@@ -58,7 +107,19 @@ func startTcpServer(b *testing.B) *TcpServer {
 	return s
 }
 
-func (s *TcpServer) Stop(b *testing.B) {
+// serveNetHttp adapts the Serve method of a net/http server for startServerOnListener.
+// Unlike fasthttp, net/http reports the listener closing as an error.
+func serveNetHttp(serve func(net.Listener) error) func(net.Listener) error {
+	return func(tcpListener net.Listener) error {
+		err := serve(tcpListener)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+func (s *TcpServer) Stop(tb testing.TB) {
 	// Shutdown the server
 	s.tcpListener.Close()
 
@@ -66,74 +127,252 @@ func (s *TcpServer) Stop(b *testing.B) {
 	select {
 	case <-s.isRunningChannel:
 	case <-time.After(time.Second):
-		b.Fatalf("server failed to stop")
+		tb.Fatalf("server failed to stop")
 	}
 }
 
-func BenchmarkNetHttpClientOverTCPToFastHttpServer(b *testing.B) {
-	// Start a server
-	server := startTcpServer(b)
-	defer server.Stop(b)
+// runClientBenchmark runs doRequest from parallel goroutines and checks that every
+// response has a 200 status code and the expected body. Each goroutine passes the
+// body of its previous response back in, so that a client which appends to a
+// caller-supplied buffer, like fasthttp.Client.Get, can reuse it.
+func runClientBenchmark(b *testing.B, doRequest func(buffer []byte) (statusCode int, body []byte, err error), expected []byte) {
+	runClientBenchmarkPerGoroutine(b, func() func(buffer []byte) (int, []byte, error) {
+		return doRequest
+	}, expected)
+}
 
-	// Create an http.Client
-	client := &http.Client{
-		// Set the maximum number of idle connections equal to the current max number of processes
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
-		},
+// runClientBenchmarkPerGoroutine is runClientBenchmark for requests that keep state in
+// each goroutine, such as a fasthttp.Request and Response that are reused for every
+// request. Each goroutine calls newDoRequest once to set up its own doRequest. When
+// the expected body differs from one request to the next, expected is nil, and
+// doRequest returns an error for a body that doesn't match.
+func runClientBenchmarkPerGoroutine(b *testing.B, newDoRequest func() func(buffer []byte) (statusCode int, body []byte, err error), expected []byte) {
+	b.RunParallel(func(pb *testing.PB) {
+		doRequest := newDoRequest()
+		var buffer []byte
+		for pb.Next() {
+			statusCode, body, err := doRequest(buffer)
+			if expected == nil {
+				checkClientResponse(b, statusCode, body, err, body)
+			} else {
+				checkClientResponse(b, statusCode, body, err, expected)
+			}
+			buffer = body
+		}
+	})
+}
+
+// checkClientResponse fails the benchmark unless the request succeeded with a 200
+// status code and the expected body
+func checkClientResponse(b *testing.B, statusCode int, body []byte, err error, expected []byte) {
+	if err != nil {
+		b.Fatalf("client request failed: %s", err)
+	}
+	if statusCode != http.StatusOK {
+		b.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
 	}
+	if !bytes.Equal(body, expected) {
+		if len(expected) > 64 {
+			b.Fatalf("expected a body of %d bytes but got %d bytes that don't match", len(expected), len(body))
+		}
+		b.Fatalf("expected body %q but got %q", expected, body)
+	}
+}
 
-	testValue := "123"
-	testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+// The number of samples that a goroutine claims from a LatencyRecorder at a time
+const latencyChunkSize = 512
+
+// LatencyRecorder collects the duration of every request in a parallel benchmark.
+// Every goroutine records into a LatencyShard of its own, which takes a chunk of
+// latencyChunkSize samples at a time from an array allocated before the timer
+// starts. Goroutines only touch shared state once per chunk, and never write to the
+// same cache lines, so recording neither allocates nor adds contention to the
+// latencies it measures.
+type LatencyRecorder struct {
+	samples   []time.Duration
+	nextChunk int64
+}
+
+// NewLatencyRecorder makes room for every request, and for the partly filled chunk
+// that each of the goroutines may leave behind
+func NewLatencyRecorder(b *testing.B, goroutines int) *LatencyRecorder {
+	chunks := b.N/latencyChunkSize + 1 + goroutines
+	samples := make([]time.Duration, chunks*latencyChunkSize)
+	// Slots that are never recorded into are told apart by being negative
+	for i := range samples {
+		samples[i] = -1
+	}
+	return &LatencyRecorder{samples: samples}
+}
+
+// LatencyShard records the latencies of one goroutine
+type LatencyShard struct {
+	recorder *LatencyRecorder
+	chunk    []time.Duration
+}
+
+func (r *LatencyRecorder) NewShard() LatencyShard {
+	return LatencyShard{recorder: r}
+}
+
+func (s *LatencyShard) Record(latency time.Duration) {
+	if len(s.chunk) == 0 {
+		end := int(atomic.AddInt64(&s.recorder.nextChunk, 1)) * latencyChunkSize
+		s.chunk = s.recorder.samples[end-latencyChunkSize : end]
+	}
+	s.chunk[0] = latency
+	s.chunk = s.chunk[1:]
+}
+
+// Report concatenates what every shard recorded, and reports the median and tail
+// latencies
+func (r *LatencyRecorder) Report(b *testing.B) {
+	claimed := r.samples[:int(atomic.LoadInt64(&r.nextChunk))*latencyChunkSize]
+	all := make([]time.Duration, 0, len(claimed))
+	for _, latency := range claimed {
+		if latency >= 0 {
+			all = append(all, latency)
+		}
+	}
+	if len(all) != b.N {
+		b.Fatalf("expected %d latencies but recorded %d", b.N, len(all))
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i] < all[j]
+	})
+	for _, percentile := range []int{50, 95, 99} {
+		// The nearest rank, so p99 of 100 samples is the 99th
+		rank := (percentile*len(all) + 99) / 100
+		b.ReportMetric(float64(all[rank-1].Nanoseconds()), fmt.Sprintf("p%d-ns", percentile))
+	}
+}
+
+// runClientBenchmarkWithLatencies is runClientBenchmark with parallelism goroutines
+// for each process, which also reports the percentiles of the time each request took
+func runClientBenchmarkWithLatencies(b *testing.B, parallelism int, doRequest func(buffer []byte) (statusCode int, body []byte, err error), expected []byte) {
+	b.SetParallelism(parallelism)
+	latencies := NewLatencyRecorder(b, parallelism*runtime.GOMAXPROCS(-1))
+	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
+		shard := latencies.NewShard()
+		var buffer []byte
 		for pb.Next() {
-			resp, err := client.Get(testUrl)
-			if err != nil {
-				b.Fatalf("client get failed: %s", err)
-			}
-			if resp.StatusCode != http.StatusOK {
-				b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
-			}
-			// Read the response body
-			body, err := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			if err != nil {
-				b.Fatalf("error while reading response body: %s", err)
-			}
-			if string(body) != testValue {
-				b.Fatalf("expected body %q but got %q", testValue, body)
-			}
+			start := time.Now()
+			statusCode, body, err := doRequest(buffer)
+			shard.Record(time.Since(start))
+			checkClientResponse(b, statusCode, body, err, expected)
+			buffer = body
 		}
 	})
+	b.StopTimer()
+
+	latencies.Report(b)
 }
 
-func BenchmarkFastHttpClientOverTCPToFastHttpServer(b *testing.B) {
+// netHttpResult reads and closes the body of a net/http response, and returns the
+// response the way runClientBenchmark expects it
+func netHttpResult(resp *http.Response, err error) (int, []byte, error) {
+	if err != nil {
+		return 0, nil, err
+	}
+	// Read the response body
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error while reading response body: %s", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// PoolSizing is the size of a client's connection pool, and how many goroutines per
+// process share it
+type PoolSizing struct {
+	conns       int
+	parallelism int
+}
+
+func (s PoolSizing) String() string {
+	// RunParallel starts parallelism goroutines for each process
+	return fmt.Sprintf("conns=%d/procs=%d", s.conns, s.parallelism*runtime.GOMAXPROCS(-1))
+}
+
+// poolSizings varies the connection pool size and the number of goroutines
+// independently, from a single connection up to one for every goroutine. The first
+// is the default of both clients in these benchmarks: one connection and one
+// goroutine for each process.
+func poolSizings() []PoolSizing {
+	procs := runtime.GOMAXPROCS(-1)
+	sizings := []PoolSizing{{conns: procs, parallelism: 1}}
+	for _, conns := range []int{1, procs, 8 * procs} {
+		for _, parallelism := range []int{1, 8, 64} {
+			// With a single process, some of these are the same
+			sizing := PoolSizing{conns: conns, parallelism: parallelism}
+			if !containsPoolSizing(sizings, sizing) {
+				sizings = append(sizings, sizing)
+			}
+		}
+	}
+	return sizings
+}
+
+func containsPoolSizing(sizings []PoolSizing, sizing PoolSizing) bool {
+	for _, s := range sizings {
+		if s == sizing {
+			return true
+		}
+	}
+	return false
+}
+
+func BenchmarkNetHttpClientOverTCPToFastHttpServer(b *testing.B) {
 	// Start a server
 	server := startTcpServer(b)
 	defer server.Stop(b)
 
-	// Create a fasthttp.Client
-	client := &fasthttp.Client{
-		// Set the maximum number of connections equal to the max number of processes
-		MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+	testValue := "123"
+	testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
+
+	for _, sizing := range poolSizings() {
+		b.Run(sizing.String(), func(b *testing.B) {
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					// Goroutines beyond the number of connections wait for one to be free
+					MaxConnsPerHost:     sizing.conns,
+					MaxIdleConnsPerHost: sizing.conns,
+				},
+			}
+			defer client.CloseIdleConnections()
+
+			runClientBenchmarkWithLatencies(b, sizing.parallelism, func(buffer []byte) (int, []byte, error) {
+				return netHttpResult(client.Get(testUrl))
+			}, []byte(testValue))
+		})
 	}
+}
+
+func BenchmarkFastHttpClientOverTCPToFastHttpServer(b *testing.B) {
+	// Start a server
+	server := startTcpServer(b)
+	defer server.Stop(b)
 
 	testValue := "123"
 	testUrl := "http://" + server.hostAddress + "/query?q=" + testValue
-	b.RunParallel(func(pb *testing.PB) {
-		var buffer []byte
-		for pb.Next() {
-			statusCode, body, err := client.Get(buffer, testUrl)
-			if err != nil {
-				b.Fatalf("client get failed: %s", err)
-			}
-			if statusCode != fasthttp.StatusOK {
-				b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
-			}
-			if string(body) != testValue {
-				b.Fatalf("expected body %q but got %q", testValue, body)
+
+	for _, sizing := range poolSizings() {
+		b.Run(sizing.String(), func(b *testing.B) {
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				MaxConnsPerHost: sizing.conns,
+				// Wait for a connection to be free rather than failing with ErrNoFreeConns
+				MaxConnWaitTimeout: time.Second,
 			}
-			buffer = body
-		}
-	})
+			defer client.CloseIdleConnections()
+
+			runClientBenchmarkWithLatencies(b, sizing.parallelism, func(buffer []byte) (int, []byte, error) {
+				// Append the body to the one from the previous request to reuse its memory
+				return client.Get(buffer, testUrl)
+			}, []byte(testValue))
+		})
+	}
 }
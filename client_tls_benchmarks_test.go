@@ -0,0 +1,321 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	testCertificate     tls.Certificate
+	testCertificateErr  error
+	testCertificateOnce sync.Once
+)
+
+// getTestCertificate returns a self-signed certificate for 127.0.0.1, which is
+// generated in memory the first time it's needed
+func getTestCertificate(tb testing.TB) tls.Certificate {
+	testCertificateOnce.Do(func() {
+		testCertificate, testCertificateErr = generateSelfSignedCertificate()
+	})
+	if testCertificateErr != nil {
+		tb.Fatalf("cannot generate certificate: %s", testCertificateErr)
+	}
+	return testCertificate
+}
+
+func generateSelfSignedCertificate() (tls.Certificate, error) {
+	// ECDSA keys are much faster to generate than RSA keys
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"fasthttp-request-perf"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certificate},
+		PrivateKey:  key,
+	}, nil
+}
+
+var (
+	testClientCA     *ClientCA
+	testClientCAErr  error
+	testClientCAOnce sync.Once
+)
+
+// ClientCA issues client certificates, and is trusted by servers that require them
+type ClientCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	nextSerial  int64
+	mu          sync.Mutex
+}
+
+// getTestClientCA returns a CA for client certificates, which is generated in memory
+// the first time it's needed
+func getTestClientCA(tb testing.TB) *ClientCA {
+	testClientCAOnce.Do(func() {
+		testClientCA, testClientCAErr = generateClientCA()
+	})
+	if testClientCAErr != nil {
+		tb.Fatalf("cannot generate client CA: %s", testClientCAErr)
+	}
+	return testClientCA
+}
+
+func generateClientCA() (*ClientCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"fasthttp-request-perf"}, CommonName: "client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientCA{
+		certificate: certificate,
+		key:         key,
+		nextSerial:  2,
+	}, nil
+}
+
+// Issue creates a short-lived client certificate with a unique serial number
+func (ca *ClientCA) Issue(tb testing.TB) tls.Certificate {
+	ca.mu.Lock()
+	serial := ca.nextSerial
+	ca.nextSerial++
+	ca.mu.Unlock()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("cannot generate client key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{Organization: []string{"fasthttp-request-perf"}, CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		tb.Fatalf("cannot issue client certificate: %s", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certificate},
+		PrivateKey:  key,
+	}
+}
+
+// CertPool returns a pool that trusts the certificates issued by the CA
+func (ca *ClientCA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	return pool
+}
+
+func startTlsServer(tb testing.TB) *TcpServer {
+	return startTlsServerWithServer(tb, &fasthttp.Server{
+		Handler: handleRequest,
+	})
+}
+
+// startTlsServerWithServer serves HTTPS with the test certificate. If the server
+// already has a TLSConfig, e.g. to require client certificates, the certificate is
+// added to it.
+func startTlsServerWithServer(tb testing.TB, server *fasthttp.Server) *TcpServer {
+	if server.TLSConfig == nil {
+		server.TLSConfig = &tls.Config{}
+	}
+	server.TLSConfig.Certificates = append(server.TLSConfig.Certificates, getTestCertificate(tb))
+
+	return startServerOnListener(tb, listenTcp(tb), func(tcpListener net.Listener) error {
+		// The certificate is already in the TLSConfig, so no files are needed
+		return server.ServeTLS(tcpListener, "", "")
+	})
+}
+
+// startMutualTlsServer serves HTTPS and requires every client to present a
+// certificate issued by the test client CA. Session tickets are disabled so that
+// every connection does a full handshake, which is the only kind that verifies the
+// client certificate.
+func startMutualTlsServer(tb testing.TB, handler fasthttp.RequestHandler) *TcpServer {
+	return startTlsServerWithServer(tb, &fasthttp.Server{
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			ClientAuth:             tls.RequireAndVerifyClientCert,
+			ClientCAs:              getTestClientCA(tb).CertPool(),
+			SessionTicketsDisabled: true,
+		},
+	})
+}
+
+// newClientTlsConfig returns a client TLS configuration that trusts the self-signed
+// test certificate
+func newClientTlsConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+	}
+}
+
+// newTlsBenchmarkConfig returns the client TLS configuration for a benchmark that
+// either reuses connections, or forces a cold handshake for every request. Session
+// tickets are disabled for cold handshakes, since resuming a session would skip most
+// of the work.
+func newTlsBenchmarkConfig(cold bool, counter *HandshakeCounter) *tls.Config {
+	tlsConfig := newClientTlsConfig()
+	tlsConfig.SessionTicketsDisabled = cold
+	tlsConfig.VerifyConnection = counter.VerifyConnection
+	return tlsConfig
+}
+
+func tlsConnectionMode(cold bool) string {
+	if cold {
+		return "cold"
+	}
+	return "pooled"
+}
+
+func BenchmarkNetHttpClientOverTLSToFastHttpServer(b *testing.B) {
+	for _, cold := range []bool{false, true} {
+		b.Run(tlsConnectionMode(cold), func(b *testing.B) {
+			// Start a server
+			server := startTlsServer(b)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			counter := &HandshakeCounter{}
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					TLSClientConfig:     newTlsBenchmarkConfig(cold, counter),
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+					// Every request has to dial and handshake for a cold connection
+					DisableKeepAlives: cold,
+				},
+			}
+
+			testValue := "123"
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(testUrl)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if string(body) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, body)
+					}
+				}
+			})
+
+			counter.Report(b)
+		})
+	}
+}
+
+func BenchmarkFastHttpClientOverTLSToFastHttpServer(b *testing.B) {
+	for _, cold := range []bool{false, true} {
+		b.Run(tlsConnectionMode(cold), func(b *testing.B) {
+			// Start a server
+			server := startTlsServer(b)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			counter := &HandshakeCounter{}
+			client := &fasthttp.Client{
+				TLSConfig: newTlsBenchmarkConfig(cold, counter),
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testValue := "123"
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				if cold {
+					// fasthttp has no option to disable keep-alive for a whole client
+					req.SetConnectionClose()
+				}
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				for pb.Next() {
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+				}
+			})
+
+			counter.Report(b)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The number of client certificates that a rotating client cycles through
+const numberOfRotatedCertificates = 8
+
+func handleClientCertificateRequest(ctx *fasthttp.RequestCtx) {
+	// Respond with the serial number of the certificate that the client presented
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.WriteString(state.PeerCertificates[0].SerialNumber.String())
+}
+
+// RotatingClientCertificate hands out a different certificate on every handshake, as a
+// client with short-lived certificates would after each rotation
+type RotatingClientCertificate struct {
+	certificates []tls.Certificate
+	serials      []string
+	calls        int64
+}
+
+func NewRotatingClientCertificate(tb testing.TB, ca *ClientCA, count int) *RotatingClientCertificate {
+	r := &RotatingClientCertificate{}
+	for i := 0; i < count; i++ {
+		certificate := ca.Issue(tb)
+		parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			tb.Fatalf("cannot parse client certificate: %s", err)
+		}
+		r.certificates = append(r.certificates, certificate)
+		r.serials = append(r.serials, parsed.SerialNumber.String())
+	}
+	return r
+}
+
+// GetClientCertificate matches the signature of tls.Config.GetClientCertificate
+func (r *RotatingClientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	call := atomic.AddInt64(&r.calls, 1)
+	return &r.certificates[(call-1)%int64(len(r.certificates))], nil
+}
+
+// LastSerial is the serial number of the certificate handed out most recently
+func (r *RotatingClientCertificate) LastSerial() string {
+	call := atomic.LoadInt64(&r.calls)
+	return r.serials[(call-1)%int64(len(r.serials))]
+}
+
+func (r *RotatingClientCertificate) Calls() int64 {
+	return atomic.LoadInt64(&r.calls)
+}
+
+// HandshakeTimer measures the time from dialing a connection until its TLS handshake
+// has completed. It assumes that only one connection is dialed at a time.
+type HandshakeTimer struct {
+	*CountingDialer
+	dialStart  int64
+	handshakes int64
+	total      int64
+}
+
+func NewHandshakeTimer(dial fasthttp.DialFunc) *HandshakeTimer {
+	return &HandshakeTimer{CountingDialer: NewCountingDialer(dial)}
+}
+
+// Dial matches the signature of fasthttp.Client.Dial
+func (t *HandshakeTimer) Dial(addr string) (net.Conn, error) {
+	atomic.StoreInt64(&t.dialStart, time.Now().UnixNano())
+	return t.CountingDialer.Dial(addr)
+}
+
+// DialNetwork matches the signature of http.Transport.Dial
+func (t *HandshakeTimer) DialNetwork(network, addr string) (net.Conn, error) {
+	return t.Dial(addr)
+}
+
+// VerifyConnection is used as the callback of the same name in the client's
+// tls.Config, which runs once the handshake is done
+func (t *HandshakeTimer) VerifyConnection(tls.ConnectionState) error {
+	atomic.AddInt64(&t.handshakes, 1)
+	atomic.AddInt64(&t.total, time.Now().UnixNano()-atomic.LoadInt64(&t.dialStart))
+	return nil
+}
+
+func (t *HandshakeTimer) Report(b *testing.B) {
+	handshakes := atomic.LoadInt64(&t.handshakes)
+	if handshakes > 0 {
+		b.ReportMetric(float64(atomic.LoadInt64(&t.total))/float64(handshakes), "handshake-ns")
+	}
+	b.ReportMetric(float64(t.Count())/float64(b.N), "dials/op")
+}
+
+// newClientCertificateTlsConfig returns a client TLS configuration that presents
+// either a static certificate or whichever certificate the rotation hands out
+func newClientCertificateTlsConfig(static *tls.Certificate, rotation *RotatingClientCertificate, timer *HandshakeTimer) *tls.Config {
+	tlsConfig := newClientTlsConfig()
+	if rotation != nil {
+		tlsConfig.GetClientCertificate = rotation.GetClientCertificate
+	} else {
+		tlsConfig.Certificates = []tls.Certificate{*static}
+	}
+	tlsConfig.VerifyConnection = timer.VerifyConnection
+	return tlsConfig
+}
+
+// verifyCertificateCallbacks checks that every dial did a handshake, and that every
+// handshake asked the rotation for a certificate
+func verifyCertificateCallbacks(b *testing.B, rotation *RotatingClientCertificate, timer *HandshakeTimer) {
+	dials, handshakes := timer.Count(), atomic.LoadInt64(&timer.handshakes)
+	if dials != handshakes {
+		b.Fatalf("expected a handshake for each of the %d dials but got %d", dials, handshakes)
+	}
+	if rotation != nil && rotation.Calls() != dials {
+		b.Fatalf("expected the certificate callback to run for each of the %d dials but it ran %d times", dials, rotation.Calls())
+	}
+}
+
+// BenchmarkTLSClientCertRotation compares presenting a static client certificate
+// against a GetClientCertificate callback that rotates through several, as clients
+// with short-lived SPIFFE-style certificates do. Every request opens a new connection
+// so that every request pays for a handshake.
+func BenchmarkTLSClientCertRotation(b *testing.B) {
+	ca := getTestClientCA(b)
+	staticCertificate := ca.Issue(b)
+	parsed, err := x509.ParseCertificate(staticCertificate.Certificate[0])
+	if err != nil {
+		b.Fatalf("cannot parse client certificate: %s", err)
+	}
+	staticSerial := parsed.SerialNumber.String()
+
+	for _, rotate := range []bool{false, true} {
+		name := "static"
+		if rotate {
+			name = "rotating"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startMutualTlsServer(b, handleClientCertificateRequest)
+			defer server.Stop(b)
+
+			var rotation *RotatingClientCertificate
+			if rotate {
+				rotation = NewRotatingClientCertificate(b, ca, numberOfRotatedCertificates)
+			}
+			timer := NewHandshakeTimer(fasthttp.Dial)
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial:              timer.DialNetwork,
+					TLSClientConfig:   newClientCertificateTlsConfig(&staticCertificate, rotation, timer),
+					DisableKeepAlives: true,
+				},
+			}
+
+			testUrl := "https://" + server.hostAddress + "/whoami"
+			serialsSeen := make(map[string]bool)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+				// Read the response body
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatalf("error while reading response body: %s", err)
+				}
+				// The server saw the certificate that was just handed out
+				expected := staticSerial
+				if rotation != nil {
+					expected = rotation.LastSerial()
+				}
+				if string(body) != expected {
+					b.Fatalf("expected body %q but got %q", expected, body)
+				}
+				serialsSeen[expected] = true
+			}
+			b.StopTimer()
+
+			timer.Report(b)
+			verifyCertificateCallbacks(b, rotation, timer)
+			b.ReportMetric(float64(len(serialsSeen)), "certs-accepted")
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startMutualTlsServer(b, handleClientCertificateRequest)
+			defer server.Stop(b)
+
+			var rotation *RotatingClientCertificate
+			if rotate {
+				rotation = NewRotatingClientCertificate(b, ca, numberOfRotatedCertificates)
+			}
+			timer := NewHandshakeTimer(fasthttp.Dial)
+			client := &fasthttp.Client{
+				Dial:      timer.Dial,
+				TLSConfig: newClientCertificateTlsConfig(&staticCertificate, rotation, timer),
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("https://" + server.hostAddress + "/whoami")
+			// fasthttp has no option to disable keep-alive for a whole client
+			req.SetConnectionClose()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			serialsSeen := make(map[string]bool)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				// The server saw the certificate that was just handed out
+				expected := staticSerial
+				if rotation != nil {
+					expected = rotation.LastSerial()
+				}
+				if string(resp.Body()) != expected {
+					b.Fatalf("expected body %q but got %q", expected, resp.Body())
+				}
+				serialsSeen[expected] = true
+			}
+			b.StopTimer()
+
+			timer.Report(b)
+			verifyCertificateCallbacks(b, rotation, timer)
+			b.ReportMetric(float64(len(serialsSeen)), "certs-accepted")
+		})
+	}
+}
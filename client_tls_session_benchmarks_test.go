@@ -0,0 +1,278 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HandshakeCounter counts full and resumed TLS handshakes when used as the
+// VerifyConnection callback of a client, which runs for both kinds of handshake
+type HandshakeCounter struct {
+	full    int64
+	resumed int64
+}
+
+func (c *HandshakeCounter) VerifyConnection(state tls.ConnectionState) error {
+	if state.DidResume {
+		atomic.AddInt64(&c.resumed, 1)
+	} else {
+		atomic.AddInt64(&c.full, 1)
+	}
+	return nil
+}
+
+func (c *HandshakeCounter) Report(b *testing.B) {
+	b.ReportMetric(float64(atomic.LoadInt64(&c.full))/float64(b.N), "full-handshakes/op")
+	b.ReportMetric(float64(atomic.LoadInt64(&c.resumed))/float64(b.N), "resumed-handshakes/op")
+}
+
+// PersistentSessionCache is a tls.ClientSessionCache that can be saved and loaded.
+// The cache returned by tls.NewLRUClientSessionCache, which fasthttp also uses by
+// default, only lives in memory, so a restarted process always starts with full
+// handshakes.
+type PersistentSessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]*tls.ClientSessionState
+}
+
+// The serialized form of a session: the ticket sent by the server and the client's
+// resumption state
+type persistedSession struct {
+	Ticket []byte
+	State  []byte
+}
+
+func NewPersistentSessionCache() *PersistentSessionCache {
+	return &PersistentSessionCache{
+		sessions: make(map[string]*tls.ClientSessionState),
+	}
+}
+
+func (c *PersistentSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session, ok := c.sessions[sessionKey]
+	return session, ok
+}
+
+func (c *PersistentSessionCache) Put(sessionKey string, session *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A nil session means the entry should be removed
+	if session == nil {
+		delete(c.sessions, sessionKey)
+		return
+	}
+	c.sessions[sessionKey] = session
+}
+
+func (c *PersistentSessionCache) Save() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	persisted := make(map[string]persistedSession, len(c.sessions))
+	for sessionKey, session := range c.sessions {
+		ticket, state, err := session.ResumptionState()
+		if err != nil {
+			return nil, err
+		}
+		stateBytes, err := state.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		persisted[sessionKey] = persistedSession{Ticket: ticket, State: stateBytes}
+	}
+	return json.Marshal(persisted)
+}
+
+func LoadPersistentSessionCache(data []byte) (*PersistentSessionCache, error) {
+	var persisted map[string]persistedSession
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return nil, err
+	}
+
+	c := NewPersistentSessionCache()
+	for sessionKey, p := range persisted {
+		state, err := tls.ParseSessionState(p.State)
+		if err != nil {
+			return nil, err
+		}
+		session, err := tls.NewResumptionState(p.Ticket, state)
+		if err != nil {
+			return nil, err
+		}
+		c.sessions[sessionKey] = session
+	}
+	return c, nil
+}
+
+// sessionCacheForProcess returns the session cache that a freshly started process
+// would have: empty for the in-memory cache, or loaded from disk when persisted
+func sessionCacheForProcess(b *testing.B, persisted bool, cacheFile string) tls.ClientSessionCache {
+	if !persisted {
+		return tls.NewLRUClientSessionCache(0)
+	}
+	data, err := os.ReadFile(cacheFile)
+	if os.IsNotExist(err) {
+		return NewPersistentSessionCache()
+	}
+	if err != nil {
+		b.Fatalf("cannot read session cache: %s", err)
+	}
+	cache, err := LoadPersistentSessionCache(data)
+	if err != nil {
+		b.Fatalf("cannot load session cache: %s", err)
+	}
+	return cache
+}
+
+// saveSessionCache writes a persistent cache to disk as the process exits
+func saveSessionCache(b *testing.B, cache tls.ClientSessionCache, cacheFile string) {
+	persistentCache, ok := cache.(*PersistentSessionCache)
+	if !ok {
+		return
+	}
+	data, err := persistentCache.Save()
+	if err != nil {
+		b.Fatalf("cannot save session cache: %s", err)
+	}
+	if err := os.WriteFile(cacheFile, data, 0600); err != nil {
+		b.Fatalf("cannot write session cache: %s", err)
+	}
+}
+
+// verifyHandshakes checks that a persisted cache resumed the session in every
+// process, while an in-memory cache never could
+func verifyHandshakes(b *testing.B, persisted bool, counter *HandshakeCounter) {
+	full, resumed := atomic.LoadInt64(&counter.full), atomic.LoadInt64(&counter.resumed)
+	if persisted && full != 0 {
+		b.Fatalf("expected every handshake to be resumed but %d of %d were full handshakes", full, full+resumed)
+	}
+	if !persisted && resumed != 0 {
+		b.Fatalf("expected only full handshakes but %d of %d were resumed", resumed, full+resumed)
+	}
+}
+
+// BenchmarkClientPersistedSessionCache simulates a short-lived process, such as a
+// cron job or a serverless function, on every iteration: it creates a new client,
+// makes a single HTTPS request, and exits
+func BenchmarkClientPersistedSessionCache(b *testing.B) {
+	testValue := "123"
+
+	for _, persisted := range []bool{false, true} {
+		name := "in-memory"
+		if persisted {
+			name = "persisted"
+		}
+
+		b.Run("NetHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTlsServer(b)
+			defer server.Stop(b)
+
+			cacheFile := filepath.Join(b.TempDir(), "sessions.json")
+			counter := &HandshakeCounter{}
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+
+			runProcess := func() {
+				cache := sessionCacheForProcess(b, persisted, cacheFile)
+				tlsConfig := newClientTlsConfig()
+				tlsConfig.ClientSessionCache = cache
+				tlsConfig.VerifyConnection = counter.VerifyConnection
+				transport := &http.Transport{
+					TLSClientConfig: tlsConfig,
+				}
+				client := &http.Client{Transport: transport}
+
+				resp, err := client.Get(testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+				}
+				// Read the response body
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					b.Fatalf("error while reading response body: %s", err)
+				}
+				if string(body) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, body)
+				}
+
+				transport.CloseIdleConnections()
+				saveSessionCache(b, cache, cacheFile)
+			}
+
+			// The first process ever run can't resume anything
+			runProcess()
+			*counter = HandshakeCounter{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runProcess()
+			}
+
+			counter.Report(b)
+			verifyHandshakes(b, persisted, counter)
+		})
+
+		b.Run("FastHttp/"+name, func(b *testing.B) {
+			// Start a server
+			server := startTlsServer(b)
+			defer server.Stop(b)
+
+			cacheFile := filepath.Join(b.TempDir(), "sessions.json")
+			counter := &HandshakeCounter{}
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+
+			runProcess := func() {
+				cache := sessionCacheForProcess(b, persisted, cacheFile)
+				tlsConfig := newClientTlsConfig()
+				tlsConfig.ClientSessionCache = cache
+				tlsConfig.VerifyConnection = counter.VerifyConnection
+				client := &fasthttp.Client{
+					TLSConfig: tlsConfig,
+				}
+
+				statusCode, body, err := client.Get(nil, testUrl)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if statusCode != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, statusCode)
+				}
+				if string(body) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, body)
+				}
+
+				client.CloseIdleConnections()
+				saveSessionCache(b, cache, cacheFile)
+			}
+
+			// The first process ever run can't resume anything
+			runProcess()
+			*counter = HandshakeCounter{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runProcess()
+			}
+
+			counter.Report(b)
+			verifyHandshakes(b, persisted, counter)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package fasthttp_request_perf
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RoundTripCountingConn counts the round trips made over a connection, i.e. how many
+// times data arrives after the connection has sent something since it last read. It
+// wraps the TCP connection, so the TLS handshake is counted as well as the request.
+type RoundTripCountingConn struct {
+	net.Conn
+	hasWritten int32
+	roundTrips *int64
+}
+
+func (c *RoundTripCountingConn) Write(b []byte) (int, error) {
+	atomic.StoreInt32(&c.hasWritten, 1)
+	return c.Conn.Write(b)
+}
+
+func (c *RoundTripCountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && atomic.CompareAndSwapInt32(&c.hasWritten, 1, 0) {
+		atomic.AddInt64(c.roundTrips, 1)
+	}
+	return n, err
+}
+
+// dialCountingRoundTrips returns a dial function whose connections add their round
+// trips to the counter
+func dialCountingRoundTrips(roundTrips *int64) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		conn, err := fasthttp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		return &RoundTripCountingConn{Conn: conn, roundTrips: roundTrips}, nil
+	}
+}
+
+// startTls13Server serves HTTPS over TLS 1.3 only, the first version with 0-RTT
+func startTls13Server(tb testing.TB) *TcpServer {
+	return startTlsServerWithServer(tb, &fasthttp.Server{
+		Handler: handleRequest,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS13,
+		},
+	})
+}
+
+// newZeroRttTlsConfig returns a TLS 1.3 client configuration that either resumes
+// sessions or always does a full handshake
+func newZeroRttTlsConfig(resume bool, counter *HandshakeCounter) *tls.Config {
+	tlsConfig := newTlsBenchmarkConfig(!resume, counter)
+	tlsConfig.MinVersion = tls.VersionTLS13
+	if resume {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return tlsConfig
+}
+
+// verifyZeroRttHandshakes checks that every connection resumed a session, except for
+// the first one that got the session ticket, or that none did
+func verifyZeroRttHandshakes(b *testing.B, resume bool, counter *HandshakeCounter) {
+	resumed := atomic.LoadInt64(&counter.resumed)
+	if resume && resumed < int64(b.N-1) {
+		b.Fatalf("expected at least %d resumed handshakes but got %d", b.N-1, resumed)
+	}
+	if !resume && resumed != 0 {
+		b.Fatalf("expected only full handshakes but %d were resumed", resumed)
+	}
+}
+
+// The connection modes that BenchmarkTLSZeroRTT compares
+var zeroRttModes = []string{"full-handshake", "resumed-1rtt", "0rtt"}
+
+// BenchmarkTLSZeroRTT compares opening a new TLS 1.3 connection for each request with
+// a full handshake, with a resumed session, and with 0-RTT early data. On loopback,
+// a round trip costs next to nothing, so round-trips/op shows the latency that each
+// would save over a real network, while ns/op shows the CPU cost of the handshake.
+//
+// A TLS 1.3 handshake takes one round trip whether or not it resumes a session, and
+// the request takes another. 0-RTT would save one by sending the request along with
+// the ClientHello, but crypto/tls supports neither sending nor accepting early data
+// over TCP, and both clients use it, so the 0rtt benchmarks are skipped. The most
+// either client can achieve is resumption, which saves the certificate exchange and
+// its verification but no round trips. Even with early data, only idempotent
+// requests like these GETs should be sent in it, since an attacker can replay it.
+func BenchmarkTLSZeroRTT(b *testing.B) {
+	testValue := "123"
+
+	for _, mode := range zeroRttModes {
+		resume := mode != "full-handshake"
+
+		b.Run("NetHttp/"+mode, func(b *testing.B) {
+			if mode == "0rtt" {
+				b.Skip("crypto/tls doesn't support sending early data over TCP")
+			}
+
+			// Start a server
+			server := startTls13Server(b)
+			defer server.Stop(b)
+
+			var roundTrips int64
+			dial := dialCountingRoundTrips(&roundTrips)
+			counter := &HandshakeCounter{}
+			// Create an http.Client
+			client := &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return dial(addr)
+					},
+					TLSClientConfig: newZeroRttTlsConfig(resume, counter),
+					// Every request has to dial and handshake
+					DisableKeepAlives: true,
+				},
+			}
+
+			testUrl := "https://" + server.hostAddress + "/query?q=" + testValue
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				statusCode, body, err := netHttpResult(client.Get(testUrl))
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if statusCode != http.StatusOK {
+					b.Fatalf("expected status code %d but got %d", http.StatusOK, statusCode)
+				}
+				if string(body) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, body)
+				}
+			}
+			b.StopTimer()
+
+			verifyZeroRttHandshakes(b, resume, counter)
+			counter.Report(b)
+			b.ReportMetric(float64(atomic.LoadInt64(&roundTrips))/float64(b.N), "round-trips/op")
+		})
+
+		b.Run("FastHttp/"+mode, func(b *testing.B) {
+			if mode == "0rtt" {
+				b.Skip("crypto/tls doesn't support sending early data over TCP")
+			}
+
+			// Start a server
+			server := startTls13Server(b)
+			defer server.Stop(b)
+
+			var roundTrips int64
+			counter := &HandshakeCounter{}
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				Dial:      dialCountingRoundTrips(&roundTrips),
+				TLSConfig: newZeroRttTlsConfig(resume, counter),
+			}
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("https://" + server.hostAddress + "/query?q=" + testValue)
+			// fasthttp has no option to disable keep-alive for a whole client
+			req.SetConnectionClose()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := client.Do(req, resp)
+				if err != nil {
+					b.Fatalf("client get failed: %s", err)
+				}
+				if resp.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+				}
+				if string(resp.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+				}
+			}
+			b.StopTimer()
+
+			verifyZeroRttHandshakes(b, resume, counter)
+			counter.Report(b)
+			b.ReportMetric(float64(atomic.LoadInt64(&roundTrips))/float64(b.N), "round-trips/op")
+		})
+	}
+}
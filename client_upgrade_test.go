@@ -0,0 +1,243 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The protocol that the servers switch to, which echoes back everything it's sent
+const upgradeProtocol = "echo"
+
+// The message that each client sends over the upgraded connection
+var upgradeMessage = []byte("ping\n")
+
+// The response that switches the connection over to upgradeProtocol
+const switchingProtocolsResponse = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + upgradeProtocol + "\r\n\r\n"
+
+// handleUpgradeRequest switches the connection over to upgradeProtocol. fasthttp
+// writes the 101 response, and then hands the connection over to the hijack handler
+// until it's done, when the connection is closed.
+func handleUpgradeRequest(ctx *fasthttp.RequestCtx) {
+	if !ctx.Request.Header.ConnectionUpgrade() || string(ctx.Request.Header.Peek("Upgrade")) != upgradeProtocol {
+		ctx.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.Response.Header.Set("Upgrade", upgradeProtocol)
+	ctx.Hijack(func(conn net.Conn) {
+		// Echo until the client closes the connection
+		io.Copy(conn, conn)
+	})
+}
+
+// handleNetHttpUpgradeRequest is handleUpgradeRequest for a net/http server. Once the
+// connection is hijacked, the server writes nothing more to it, so the handler has to
+// write the 101 response itself.
+func handleNetHttpUpgradeRequest(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Connection"), "Upgrade") || r.Header.Get("Upgrade") != upgradeProtocol {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	conn, buffers, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	buffers.WriteString(switchingProtocolsResponse)
+	if err := buffers.Flush(); err != nil {
+		return
+	}
+	// Echo until the client closes the connection, starting with anything the server
+	// had already read
+	io.Copy(conn, buffers.Reader)
+}
+
+func startUpgradeServer(tb testing.TB, netHttpServer bool) *TcpServer {
+	if netHttpServer {
+		server := &http.Server{
+			Handler: http.HandlerFunc(handleNetHttpUpgradeRequest),
+		}
+		return startServerOnListener(tb, listenTcp(tb), serveNetHttp(server.Serve))
+	}
+	return startTcpServerWithHandler(tb, handleUpgradeRequest)
+}
+
+func upgradeServerName(netHttpServer bool) string {
+	if netHttpServer {
+		return "to-net-http-server"
+	}
+	return "to-fasthttp-server"
+}
+
+// exchangeUpgradeMessage sends upgradeMessage over the upgraded connection and checks
+// that it's echoed back, which shows that the connection now carries raw bytes
+func exchangeUpgradeMessage(conn io.ReadWriter) error {
+	if _, err := conn.Write(upgradeMessage); err != nil {
+		return fmt.Errorf("cannot write to the upgraded connection: %s", err)
+	}
+	var echo [16]byte
+	if _, err := io.ReadFull(conn, echo[:len(upgradeMessage)]); err != nil {
+		return fmt.Errorf("cannot read from the upgraded connection: %s", err)
+	}
+	if string(echo[:len(upgradeMessage)]) != string(upgradeMessage) {
+		return fmt.Errorf("expected echo %q but got %q", upgradeMessage, echo[:len(upgradeMessage)])
+	}
+	return nil
+}
+
+// upgradeWithNetHttp upgrades a connection with an http.Client. For a 101 response,
+// net/http takes the connection out of its pool and hands it over as the body, which
+// can be written to as well as read from.
+func upgradeWithNetHttp(client *http.Client, testUrl string) error {
+	req, err := http.NewRequest(http.MethodGet, testUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", upgradeProtocol)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client get failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("expected status code %d but got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if upgrade := resp.Header.Get("Upgrade"); upgrade != upgradeProtocol {
+		return fmt.Errorf("expected an upgrade to %q but got %q", upgradeProtocol, upgrade)
+	}
+	conn, ok := resp.Body.(io.ReadWriter)
+	if !ok {
+		return fmt.Errorf("expected the body of a 101 response to be writable but got %T", resp.Body)
+	}
+	return exchangeUpgradeMessage(conn)
+}
+
+// upgradeWithFastHttp upgrades a connection without a fasthttp.Client, which would
+// put the connection back in its pool. As WebSocket clients built on fasthttp do, it
+// dials the connection itself, and writes the request and reads the response on it.
+func upgradeWithFastHttp(hostAddress string) error {
+	conn, err := fasthttp.Dial(hostAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://" + hostAddress + "/upgrade")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", upgradeProtocol)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = req.Write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		// A 1xx response has no body, so nothing past the headers is read
+		err = resp.Read(reader)
+	}
+	if err != nil {
+		return fmt.Errorf("client get failed: %s", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		return fmt.Errorf("expected status code %d but got %d", fasthttp.StatusSwitchingProtocols, resp.StatusCode())
+	}
+	if upgrade := resp.Header.Peek("Upgrade"); string(upgrade) != upgradeProtocol {
+		return fmt.Errorf("expected an upgrade to %q but got %q", upgradeProtocol, upgrade)
+	}
+	// The reader may already hold bytes that the server sent after the response
+	return exchangeUpgradeMessage(struct {
+		io.Reader
+		io.Writer
+	}{reader, conn})
+}
+
+func TestClientProtocolUpgrade(t *testing.T) {
+	for _, netHttpServer := range []bool{false, true} {
+		serverName := upgradeServerName(netHttpServer)
+
+		t.Run("NetHttp/"+serverName, func(t *testing.T) {
+			// Start a server
+			server := startUpgradeServer(t, netHttpServer)
+			defer server.Stop(t)
+
+			// Create an http.Client
+			client := &http.Client{}
+			if err := upgradeWithNetHttp(client, "http://"+server.hostAddress+"/upgrade"); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		t.Run("FastHttp/"+serverName, func(t *testing.T) {
+			// Start a server
+			server := startUpgradeServer(t, netHttpServer)
+			defer server.Stop(t)
+
+			if err := upgradeWithFastHttp(server.hostAddress); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// BenchmarkClientProtocolUpgrade dials a connection, upgrades it and exchanges one
+// message for every operation, which is the handshake that a WebSocket client makes
+// before its first message. An upgraded connection can't go back to a pool, so the
+// dial is part of the cost.
+func BenchmarkClientProtocolUpgrade(b *testing.B) {
+	for _, netHttpServer := range []bool{false, true} {
+		serverName := upgradeServerName(netHttpServer)
+
+		b.Run("NetHttp/"+serverName, func(b *testing.B) {
+			// Start a server
+			server := startUpgradeServer(b, netHttpServer)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{}
+
+			testUrl := "http://" + server.hostAddress + "/upgrade"
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := upgradeWithNetHttp(client, testUrl); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+
+		b.Run("FastHttp/"+serverName, func(b *testing.B) {
+			// Start a server
+			server := startUpgradeServer(b, netHttpServer)
+			defer server.Stop(b)
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := upgradeWithFastHttp(server.hostAddress); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
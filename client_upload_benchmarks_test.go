@@ -0,0 +1,127 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// handleUploadRequest responds with the length and checksum of the request body,
+// so that clients can verify the server received exactly what they sent
+func handleUploadRequest(ctx *fasthttp.RequestCtx) {
+	body := ctx.PostBody()
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.WriteString(uploadSummary(body))
+}
+
+func uploadSummary(body []byte) string {
+	return fmt.Sprintf("%d %08x", len(body), crc32.ChecksumIEEE(body))
+}
+
+func BenchmarkClientMultiReaderBody(b *testing.B) {
+	// A small metadata block framed in front of the payload
+	header := []byte("{\"type\":\"upload\",\"version\":1}\n")
+
+	for _, payloadSize := range []int{64 << 10, 1 << 20} {
+		payload := makePayload(payloadSize)
+		bodySize := len(header) + len(payload)
+		testValue := uploadSummary(append(append([]byte{}, header...), payload...))
+
+		b.Run(fmt.Sprintf("NetHttp/size=%dKB", payloadSize>>10), func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleUploadRequest)
+			defer server.Stop(b)
+
+			// Create an http.Client
+			client := &http.Client{
+				// Set the maximum number of idle connections equal to the current max number of processes
+				Transport: &http.Transport{
+					MaxIdleConnsPerHost: runtime.GOMAXPROCS(-1),
+				},
+			}
+
+			testUrl := "http://" + server.hostAddress + "/upload"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					// net/http accepts any io.Reader as the request body
+					body := io.MultiReader(bytes.NewReader(header), bytes.NewReader(payload))
+					req, err := http.NewRequest(http.MethodPost, testUrl, body)
+					if err != nil {
+						b.Fatalf("cannot create request: %s", err)
+					}
+					// The length of an io.MultiReader isn't known, so set it to avoid chunking
+					req.ContentLength = int64(bodySize)
+
+					resp, err := client.Do(req)
+					if err != nil {
+						b.Fatalf("client post failed: %s", err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						b.Fatalf("expected status code %d but got %d", http.StatusOK, resp.StatusCode)
+					}
+					// Read the response body
+					respBody, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						b.Fatalf("error while reading response body: %s", err)
+					}
+					if string(respBody) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, respBody)
+					}
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("FastHttp/size=%dKB", payloadSize>>10), func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleUploadRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://" + server.hostAddress + "/upload"
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req := fasthttp.AcquireRequest()
+					req.SetRequestURI(testUrl)
+					req.Header.SetMethod(fasthttp.MethodPost)
+					// fasthttp needs the reader to be set as a body stream
+					body := io.MultiReader(bytes.NewReader(header), bytes.NewReader(payload))
+					req.SetBodyStream(body, bodySize)
+
+					resp := fasthttp.AcquireResponse()
+
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client post failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+
+					// Release the request and response
+					fasthttp.ReleaseRequest(req)
+					fasthttp.ReleaseResponse(resp)
+				}
+			})
+		})
+	}
+}
@@ -1,5 +1,15 @@
 module github.com/davidbacisin/fasthttp-request-perf
 
-go 1.13
+go 1.21
 
-require github.com/valyala/fasthttp v1.34.0
+require (
+	github.com/valyala/fasthttp v1.46.0
+	golang.org/x/net v0.8.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/klauspost/compress v1.16.3 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+)
@@ -0,0 +1,206 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Headers that only apply to a single connection, which a proxy must not forward.
+// Any header named in the Connection header is hop-by-hop as well.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// The hop-by-hop headers of an incoming request, including one that's only
+// hop-by-hop because the Connection header names it
+var incomingHopByHopHeaders = [][2]string{
+	{"Connection", "keep-alive, X-Forwarded-Hop"},
+	{"Keep-Alive", "timeout=5, max=1000"},
+	{"Proxy-Authorization", "Basic dXNlcjpzZWNyZXQ="},
+	{"Te", "trailers"},
+	{"Upgrade", "websocket"},
+	{"X-Forwarded-Hop", "1"},
+}
+
+// makeProxiedHeaders returns the given number of end-to-end headers, which a proxy
+// must forward unchanged
+func makeProxiedHeaders(count int) [][2]string {
+	headers := make([][2]string, count)
+	for i := range headers {
+		headers[i] = [2]string{fmt.Sprintf("X-Header-%d", i), fmt.Sprintf("value-%d", i)}
+	}
+	return headers
+}
+
+// isHopByHopHeader reports whether a proxy must drop the header, given the value of
+// the Connection header of the same request
+func isHopByHopHeader(key, connection []byte) bool {
+	for _, name := range hopByHopHeaders {
+		if bytes.EqualFold(key, []byte(name)) {
+			return true
+		}
+	}
+	for len(connection) > 0 {
+		var token []byte
+		token, connection, _ = bytes.Cut(connection, []byte(","))
+		if bytes.EqualFold(bytes.TrimSpace(token), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFastHttpHeadersVisitAll copies the end-to-end headers one at a time, skipping
+// the hop-by-hop ones
+func copyFastHttpHeadersVisitAll(dst, src *fasthttp.RequestHeader) {
+	connection := src.Peek(fasthttp.HeaderConnection)
+	src.VisitAll(func(key, value []byte) {
+		if !isHopByHopHeader(key, connection) {
+			dst.SetBytesKV(key, value)
+		}
+	})
+}
+
+// copyFastHttpHeadersCopyTo copies every header at once, then deletes the hop-by-hop
+// ones from the copy
+func copyFastHttpHeadersCopyTo(dst, src *fasthttp.RequestHeader) {
+	src.CopyTo(dst)
+	// Delete the headers that the Connection header names before deleting it
+	connection := dst.Peek(fasthttp.HeaderConnection)
+	for len(connection) > 0 {
+		var token []byte
+		token, connection, _ = bytes.Cut(connection, []byte(","))
+		if token = bytes.TrimSpace(token); len(token) > 0 {
+			dst.DelBytes(token)
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		dst.Del(name)
+	}
+}
+
+// copyNetHttpHeaders copies every header into a new map, then deletes the hop-by-hop
+// ones from the copy, as httputil.ReverseProxy does
+func copyNetHttpHeaders(src http.Header) http.Header {
+	dst := make(http.Header, len(src))
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+	for _, connection := range dst["Connection"] {
+		for _, token := range strings.Split(connection, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				dst.Del(token)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		dst.Del(name)
+	}
+	return dst
+}
+
+// verifyFastHttpProxiedHeaders checks that the copy has exactly the end-to-end headers
+func verifyFastHttpProxiedHeaders(b *testing.B, header *fasthttp.RequestHeader, expected [][2]string) {
+	for _, h := range expected {
+		if value := string(header.Peek(h[0])); value != h[1] {
+			b.Fatalf("expected header %s to be %q but got %q", h[0], h[1], value)
+		}
+	}
+	for _, h := range incomingHopByHopHeaders {
+		if value := header.Peek(h[0]); len(value) > 0 {
+			b.Fatalf("expected hop-by-hop header %s to be dropped but got %q", h[0], value)
+		}
+	}
+	count := 0
+	header.VisitAll(func(key, value []byte) {
+		count++
+	})
+	if count != len(expected) {
+		b.Fatalf("expected %d headers but got %d", len(expected), count)
+	}
+}
+
+// verifyNetHttpProxiedHeaders checks that the copy has exactly the end-to-end headers
+func verifyNetHttpProxiedHeaders(b *testing.B, header http.Header, expected [][2]string) {
+	for _, h := range expected {
+		if value := header.Get(h[0]); value != h[1] {
+			b.Fatalf("expected header %s to be %q but got %q", h[0], h[1], value)
+		}
+	}
+	for _, h := range incomingHopByHopHeaders {
+		if value := header.Get(h[0]); value != "" {
+			b.Fatalf("expected hop-by-hop header %s to be dropped but got %q", h[0], value)
+		}
+	}
+	if len(header) != len(expected) {
+		b.Fatalf("expected %d headers but got %d", len(expected), len(header))
+	}
+}
+
+// BenchmarkProxyHeaderCopy copies the headers of an incoming request to the outgoing
+// one, as a reverse proxy does, for requests with a few up to many end-to-end headers
+// alongside the same hop-by-hop headers
+func BenchmarkProxyHeaderCopy(b *testing.B) {
+	for _, count := range []int{5, 20, 50} {
+		expected := makeProxiedHeaders(count)
+		incoming := append(append([][2]string(nil), expected...), incomingHopByHopHeaders...)
+
+		var src fasthttp.RequestHeader
+		srcNetHttp := make(http.Header)
+		for _, h := range incoming {
+			src.Set(h[0], h[1])
+			srcNetHttp.Set(h[0], h[1])
+		}
+
+		fastHttpStrategies := []struct {
+			name string
+			copy func(dst, src *fasthttp.RequestHeader)
+		}{
+			{"VisitAll", copyFastHttpHeadersVisitAll},
+			{"CopyTo", copyFastHttpHeadersCopyTo},
+		}
+		for _, strategy := range fastHttpStrategies {
+			b.Run(fmt.Sprintf("FastHttp/%s/headers=%d", strategy.name, count), func(b *testing.B) {
+				// The outgoing request is reused, as it would be when acquired from a pool
+				var dst fasthttp.RequestHeader
+				strategy.copy(&dst, &src)
+				verifyFastHttpProxiedHeaders(b, &dst, expected)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					dst.Reset()
+					strategy.copy(&dst, &src)
+				}
+			})
+		}
+
+		b.Run(fmt.Sprintf("NetHttp/headers=%d", count), func(b *testing.B) {
+			verifyNetHttpProxiedHeaders(b, copyNetHttpHeaders(srcNetHttp), expected)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Each outgoing request gets a header map of its own
+				if dst := copyNetHttpHeaders(srcNetHttp); len(dst) != count {
+					b.Fatalf("expected %d headers but got %d", count, len(dst))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package fasthttp_request_perf
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// The largest body that the buffering relay accepts
+const maxRelayBodySize = 32 << 20
+
+// handleStreamedUploadRequest responds like handleUploadRequest, but reads the
+// body as a stream, so that the downstream server holds none of it in memory
+func handleStreamedUploadRequest(ctx *fasthttp.RequestCtx) {
+	body := ctx.RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.PostBody())
+	}
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(hash, body)
+	if err != nil {
+		ctx.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	fmt.Fprintf(ctx, "%d %08x", n, hash.Sum32())
+}
+
+// handleProxyRelayRequest returns a handler that forwards each request body to the
+// downstream URL and passes the response back. If the proxy server streams request
+// bodies, the body is relayed as it arrives; otherwise it has already been read in
+// full.
+func handleProxyRelayRequest(client *fasthttp.Client, downstreamUrl string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(downstreamUrl)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.Header.SetContentTypeBytes(ctx.Request.Header.ContentType())
+		if stream := ctx.RequestBodyStream(); stream != nil {
+			// Hide the type of the stream, or the relayed request would release it to
+			// fasthttp's pool once written, and ctx would release it a second time
+			req.SetBodyStream(struct{ io.Reader }{stream}, ctx.Request.Header.ContentLength())
+		} else {
+			// The body belongs to ctx, which outlives the relayed request
+			req.SetBodyRaw(ctx.PostBody())
+		}
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		if err := client.Do(req, resp); err != nil {
+			ctx.Error("Bad Gateway", fasthttp.StatusBadGateway)
+			return
+		}
+		ctx.SetStatusCode(resp.StatusCode())
+		ctx.SetBody(resp.Body())
+	}
+}
+
+// BenchmarkFastHttpProxyRelay uploads a body through a fasthttp proxy, which relays
+// it with a fasthttp.Client to a downstream server that streams it into a checksum.
+// The buffered relay reads the whole body before forwarding it, so its peak heap
+// grows with the body, while the streamed relay forwards it a buffer at a time and
+// should stay flat. The downstream's checksum is passed back through the proxy to
+// verify that the whole body arrived.
+func BenchmarkFastHttpProxyRelay(b *testing.B) {
+	for _, bodySize := range []int{1 << 20, 16 << 20} {
+		payload := makePayload(bodySize)
+		testValue := uploadSummary(payload)
+
+		for _, streamRequestBody := range []bool{false, true} {
+			name := "buffered"
+			if streamRequestBody {
+				name = "streamed"
+			}
+
+			b.Run(fmt.Sprintf("%s/size=%dMB", name, bodySize>>20), func(b *testing.B) {
+				// Start a server and a proxy in front of it
+				server := startTcpServerWithServer(b, &fasthttp.Server{
+					Handler:           handleStreamedUploadRequest,
+					StreamRequestBody: true,
+				})
+				defer server.Stop(b)
+				proxyServer := &fasthttp.Server{
+					Handler:            handleProxyRelayRequest(&fasthttp.Client{}, "http://"+server.hostAddress+"/upload"),
+					MaxRequestBodySize: maxRelayBodySize,
+					StreamRequestBody:  streamRequestBody,
+				}
+				proxy := startServerOnListener(b, listenTcpOn(b, proxyHostAddress), proxyServer.Serve)
+				defer proxy.Stop(b)
+
+				// Create a client
+				client := &fasthttp.Client{}
+
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI("http://" + proxy.hostAddress + "/upload")
+				req.Header.SetMethod(fasthttp.MethodPost)
+				req.Header.SetContentType("application/octet-stream")
+				// Avoid copying the body so that only the relay's memory is measured
+				req.SetBodyRaw(payload)
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				heap := NewHeapSampler()
+				b.SetBytes(int64(bodySize))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client post failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+					heap.Sample(b)
+				}
+				b.StopTimer()
+
+				heap.Report(b)
+			})
+		}
+	}
+}
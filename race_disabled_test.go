@@ -0,0 +1,5 @@
+//go:build !race
+
+package fasthttp_request_perf
+
+const raceEnabled = false
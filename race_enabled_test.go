@@ -0,0 +1,7 @@
+//go:build race
+
+package fasthttp_request_perf
+
+// The race detector makes sync.Pool drop items at random, so tests that count
+// allocations can't rely on pooled buffers being reused
+const raceEnabled = true
@@ -0,0 +1,182 @@
+package fasthttp_request_perf
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func handleRepeatedPeekRequest(ctx *fasthttp.RequestCtx) {
+	// The number of middleware layers is passed by the client
+	peeks := ctx.QueryArgs().GetUintOrZero("peeks")
+
+	// Each middleware layer checks the Authorization header independently,
+	// as if it knew nothing about the layers before it
+	var authorization []byte
+	for i := 0; i < peeks; i++ {
+		authorization = ctx.Request.Header.Peek("Authorization")
+		if len(authorization) == 0 {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(authorization)
+}
+
+func BenchmarkFastHttpServerRepeatedPeek(b *testing.B) {
+	testValue := "Bearer 0123456789abcdef"
+
+	for _, peeks := range []int{1, 5, 20} {
+		// Calling the handler directly isolates the cost of the peeks from the network
+		b.Run(fmt.Sprintf("Handler/peeks=%d", peeks), func(b *testing.B) {
+			var ctx fasthttp.RequestCtx
+			ctx.Request.SetRequestURI(fmt.Sprintf("/query?peeks=%d", peeks))
+			ctx.Request.Header.Set("Authorization", testValue)
+			// Surround the Authorization header with others so each peek has to scan
+			for i := 0; i < 10; i++ {
+				ctx.Request.Header.Set(fmt.Sprintf("X-Header-%d", i), "value")
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctx.Response.Reset()
+				handleRepeatedPeekRequest(&ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, ctx.Response.StatusCode())
+				}
+				if string(ctx.Response.Body()) != testValue {
+					b.Fatalf("expected body %q but got %q", testValue, ctx.Response.Body())
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("OverTCP/peeks=%d", peeks), func(b *testing.B) {
+			// Start a server
+			server := startTcpServerWithHandler(b, handleRepeatedPeekRequest)
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := fmt.Sprintf("http://%s/query?peeks=%d", server.hostAddress, peeks)
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				req.Header.Set("Authorization", testValue)
+				for i := 0; i < 10; i++ {
+					req.Header.Set(fmt.Sprintf("X-Header-%d", i), "value")
+				}
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				for pb.Next() {
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client get failed: %s", err)
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+				}
+			})
+		})
+	}
+}
+
+// Uploads larger than this are rejected by the server
+const maxRequestBodySize = 1 << 20
+
+// handleRequestBodyTooLarge responds to uploads over the limit with 413 rather than
+// the generic 400 that fasthttp sends for any request it fails to read
+func handleRequestBodyTooLarge(ctx *fasthttp.RequestCtx, err error) {
+	if err == fasthttp.ErrBodyTooLarge {
+		ctx.Error("Request Entity Too Large", fasthttp.StatusRequestEntityTooLarge)
+		return
+	}
+	ctx.Error("Bad Request", fasthttp.StatusBadRequest)
+}
+
+// silentLogger discards the errors that a fasthttp.Server logs
+type silentLogger struct{}
+
+func (silentLogger) Printf(format string, args ...interface{}) {}
+
+func BenchmarkFastHttpServerMaxRequestBodySize(b *testing.B) {
+	bodySizes := []int{
+		maxRequestBodySize - 64<<10,
+		maxRequestBodySize,
+		maxRequestBodySize + 1,
+		maxRequestBodySize + 64<<10,
+	}
+
+	for _, bodySize := range bodySizes {
+		body := makePayload(bodySize)
+		isOverLimit := bodySize > maxRequestBodySize
+
+		b.Run(fmt.Sprintf("size=limit%+d", bodySize-maxRequestBodySize), func(b *testing.B) {
+			// The limit is a server option, which requires an explicit fasthttp.Server
+			server := startTcpServerWithServer(b, &fasthttp.Server{
+				Handler:            handleUploadRequest,
+				MaxRequestBodySize: maxRequestBodySize,
+				ErrorHandler:       handleRequestBodyTooLarge,
+				// Every rejected upload would otherwise be logged
+				Logger: silentLogger{},
+			})
+			defer server.Stop(b)
+
+			// Create a fasthttp.Client
+			client := &fasthttp.Client{
+				// Set the maximum number of connections equal to the max number of processes
+				MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+			}
+
+			testUrl := "http://" + server.hostAddress + "/upload"
+			testValue := uploadSummary(body)
+			b.SetBytes(int64(bodySize))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				req := fasthttp.AcquireRequest()
+				defer fasthttp.ReleaseRequest(req)
+				req.SetRequestURI(testUrl)
+				req.Header.SetMethod(fasthttp.MethodPost)
+				req.SetBodyRaw(body)
+
+				resp := fasthttp.AcquireResponse()
+				defer fasthttp.ReleaseResponse(resp)
+
+				for pb.Next() {
+					err := client.Do(req, resp)
+					if err != nil {
+						b.Fatalf("client post failed: %s", err)
+					}
+					if isOverLimit {
+						// The server rejects the upload without calling the handler
+						if resp.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+							b.Fatalf("expected status code %d but got %d", fasthttp.StatusRequestEntityTooLarge, resp.StatusCode())
+						}
+						continue
+					}
+					if resp.StatusCode() != fasthttp.StatusOK {
+						b.Fatalf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+					}
+					if string(resp.Body()) != testValue {
+						b.Fatalf("expected body %q but got %q", testValue, resp.Body())
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package fasthttp_request_perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// writeClosingPipeline writes the given number of requests to the connection at once,
+// without waiting for any responses, and asks the server to close the connection
+// after the last one
+func writeClosingPipeline(conn net.Conn, hostAddress string, requests int) error {
+	var pipeline []byte
+	for i := 0; i < requests; i++ {
+		pipeline = append(pipeline, "GET /query?q="+strconv.Itoa(i)+" HTTP/1.1\r\nHost: "+hostAddress+"\r\n"...)
+		if i == requests-1 {
+			pipeline = append(pipeline, "Connection: close\r\n"...)
+		}
+		pipeline = append(pipeline, "\r\n"...)
+	}
+	_, err := conn.Write(pipeline)
+	return err
+}
+
+// readClosingPipeline reads the responses to writeClosingPipeline in order, then
+// checks that the server closed the connection after the last of them
+func readClosingPipeline(conn net.Conn, reader *bufio.Reader, resp *fasthttp.Response, requests int) error {
+	for i := 0; i < requests; i++ {
+		resp.Reset()
+		if err := resp.Read(reader); err != nil {
+			return fmt.Errorf("cannot read response %d of %d: %s", i+1, requests, err)
+		}
+		if resp.StatusCode() != fasthttp.StatusOK {
+			return fmt.Errorf("expected status code %d but got %d", fasthttp.StatusOK, resp.StatusCode())
+		}
+		if testValue := strconv.Itoa(i); string(resp.Body()) != testValue {
+			return fmt.Errorf("expected body %q but got %q", testValue, resp.Body())
+		}
+		// Only the last response announces that the connection is closing
+		if isLast := i == requests-1; resp.ConnectionClose() != isLast {
+			return fmt.Errorf("expected response %d of %d to have Connection: close %t", i+1, requests, isLast)
+		}
+	}
+
+	// Rather than wait for the server to give up on an idle connection, fail if the
+	// connection isn't closed right away
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := reader.Read(make([]byte, 1)); err != io.EOF {
+		return fmt.Errorf("expected the server to close the connection but read %d bytes and got: %v", n, err)
+	}
+	return nil
+}
+
+func TestFastHttpServerPipelineWithClose(t *testing.T) {
+	// Start a server
+	server := startTcpServer(t)
+	defer server.Stop(t)
+
+	for _, requests := range []int{1, 2, 10} {
+		t.Run(fmt.Sprintf("requests=%d", requests), func(t *testing.T) {
+			conn, err := net.Dial("tcp4", server.hostAddress)
+			if err != nil {
+				t.Fatalf("cannot connect to server: %s", err)
+			}
+			defer conn.Close()
+
+			if err := writeClosingPipeline(conn, server.hostAddress, requests); err != nil {
+				t.Fatalf("cannot write pipeline: %s", err)
+			}
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			if err := readClosingPipeline(conn, bufio.NewReader(conn), resp, requests); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// BenchmarkFastHttpServerPipelineWithClose measures the throughput of pipelines that
+// end by closing the connection, so each one includes dialing a new connection
+func BenchmarkFastHttpServerPipelineWithClose(b *testing.B) {
+	for _, requests := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("requests=%d", requests), func(b *testing.B) {
+			// Start a server
+			server := startTcpServer(b)
+			defer server.Stop(b)
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			reader := bufio.NewReader(nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, err := net.Dial("tcp4", server.hostAddress)
+				if err != nil {
+					b.Fatalf("cannot connect to server: %s", err)
+				}
+				if err := writeClosingPipeline(conn, server.hostAddress, requests); err != nil {
+					b.Fatalf("cannot write pipeline: %s", err)
+				}
+				reader.Reset(conn)
+				if err := readClosingPipeline(conn, reader, resp, requests); err != nil {
+					b.Fatal(err)
+				}
+				conn.Close()
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.N*requests)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+}